@@ -2,9 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/codecrafters-io/grep-starter-go/pkg/patterns"
 )
@@ -12,44 +17,204 @@ import (
 // Ensures gofmt doesn't remove the "bytes" import above (feel free to remove this!)
 var _ = bytes.ContainsAny
 
-// Usage: echo <input_text> | your_program.sh -E <pattern>
+// Usage: echo <input_text> | your_program.sh [-o | --replace <text>] [--timeout <duration>] [--lint] -E <pattern> [file...]
 func main() {
-	if len(os.Args) < 3 || os.Args[1] != "-E" {
-		fmt.Fprintf(os.Stderr, "usage: mygrep -E <pattern>\n")
+	flags := flag.NewFlagSet("mygrep", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	pattern := flags.String("E", "", "")
+	onlyMatching := flags.Bool("o", false, "")
+	replace := flags.String("replace", "", "")
+	timeout := flags.Duration("timeout", 0, "")
+	lint := flags.Bool("lint", false, "")
+	if err := flags.Parse(os.Args[1:]); err != nil || !isFlagSet(flags, "E") ||
+		*onlyMatching && isFlagSet(flags, "replace") {
+		fmt.Fprintf(os.Stderr, "usage: mygrep [-o | --replace <text>] [--timeout <duration>] [--lint] -E <pattern> [file...]\n")
 		os.Exit(2) // 1 means no lines were selected, >1 means error
 	}
 
-	pattern := os.Args[2]
-
-	line, err := io.ReadAll(os.Stdin) // assume we're only dealing with a single line
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: read input text: %v\n", err)
-		os.Exit(2)
+	if *lint {
+		// Report on the pattern rather than search with it
+		os.Exit(lintPattern(*pattern))
 	}
 
-	runes := bytes.Runes(line)
-	ok, err := matchLine(runes, pattern)
+	p, err := compilePattern(*pattern)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		var parseErr *patterns.ParseError
+		if errors.As(err, &parseErr) {
+			fmt.Fprintf(os.Stderr, "  %s\n  %s^\n", *pattern, caretIndent(*pattern, parseErr.Offset))
+		}
 		os.Exit(2)
 	}
 
-	if !ok {
+	// Like grep, read standard input if no files are named, and label
+	// output with the file it came from if there are several
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	selected, failed := false, false
+	for _, file := range files {
+		input, name, err := readInput(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: read %s: %v\n", name, err)
+			failed = true
+			continue
+		}
+		prefix := ""
+		if len(files) > 1 {
+			prefix = name + ":"
+		}
+
+		for n, line := range splitLines(input) {
+			var replacement *string
+			if isFlagSet(flags, "replace") {
+				replacement = replace
+			}
+			matched, output, err := searchLine(p, bytes.Runes(line), *timeout, *onlyMatching, replacement)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %s:%d: %v\n", name, n+1, err)
+				failed = true
+				continue
+			}
+			if !matched {
+				continue
+			}
+			selected = true
+			for _, text := range output {
+				fmt.Println(prefix + text)
+			}
+		}
+	}
+
+	switch {
+	case failed:
+		os.Exit(2)
+	case !selected:
 		os.Exit(1)
 	}
 
 	// default exit code is 0 which means success
 }
 
-func matchLine(line []rune, pattern string) (bool, error) {
+// readInput returns the contents of file, or of standard input if file is
+// "-", along with the name to report it by
+func readInput(file string) ([]byte, string, error) {
+	if file == "-" {
+		input, err := io.ReadAll(os.Stdin)
+		return input, "(standard input)", err
+	}
+	input, err := os.ReadFile(file)
+	return input, file, err
+}
+
+// searchLine reports whether p matches line and returns what to print for it:
+// with onlyMatching, like grep -o, each non-empty match on a line of its own,
+// and with a replacement, like rg -r, the line with every match replaced. The
+// search gives up once timeout has passed unless it is zero.
+func searchLine(p *patterns.Pattern, line []rune, timeout time.Duration, onlyMatching bool, replacement *string) (bool, []string, error) {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+	timedOut := func(err error) error {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("match took longer than --timeout %v", timeout)
+		}
+		return err
+	}
+
+	matched, err := p.MatchContext(ctx, line)
+	if err != nil || !matched {
+		return false, nil, timedOut(err)
+	}
+
+	var output []string
+	switch {
+	case onlyMatching:
+		spans, err := p.FindAllIndexContext(ctx, line, -1)
+		if err != nil {
+			return false, nil, timedOut(err)
+		}
+		for _, span := range spans {
+			if span.End > span.Start {
+				output = append(output, string(line[span.Start:span.End]))
+			}
+		}
+	case replacement != nil:
+		replaced, err := p.ReplaceAllContext(ctx, line, []rune(*replacement))
+		if err != nil {
+			return false, nil, timedOut(err)
+		}
+		output = append(output, string(replaced))
+	}
+	return true, output, nil
+}
+
+// splitLines splits input into lines without their newlines. A final newline
+// ends the last line rather than starting an empty one.
+func splitLines(input []byte) [][]byte {
+	if len(input) == 0 {
+		return nil
+	}
+	return bytes.Split(bytes.TrimSuffix(input, []byte("\n")), []byte("\n"))
+}
+
+func compilePattern(pattern string) (*patterns.Pattern, error) {
 	if len(pattern) == 0 {
-		return false, fmt.Errorf("empty pattern")
+		return nil, fmt.Errorf("empty pattern")
 	}
 
-	p, err := patterns.ParsePattern(pattern)
+	// -E patterns are POSIX extended regular expressions, which match leftmost-longest
+	p, err := patterns.ParsePattern(pattern, patterns.ParseOptions{Longest: true})
 	if err != nil {
-		return false, fmt.Errorf("invalid pattern: %v", err)
+		return nil, fmt.Errorf("invalid pattern: %w", err)
 	}
+	return p, nil
+}
+
+// lintPattern prints what patterns.Analyze finds in pattern, read as
+// compilePattern would, and returns the exit status: 0 if there is nothing to
+// report, 1 if there are only warnings and 2 if the pattern does not parse
+func lintPattern(pattern string) int {
+	status := 0
+	for _, d := range patterns.Analyze(pattern, patterns.ParseOptions{Longest: true}) {
+		fmt.Printf("%v\n  %s\n  %s^\n", d, pattern, caretIndent(pattern, d.Offset))
+		switch {
+		case d.Severity == patterns.SeverityError:
+			status = 2
+		case status == 0:
+			status = 1
+		}
+	}
+	return status
+}
 
-	return p.Match(line), nil
+// isFlagSet reports whether the flag called name was given on the command line
+func isFlagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// caretIndent returns the whitespace that lines a caret up under the rune at
+// offset when printed below pattern, keeping any tabs so the columns agree
+func caretIndent(pattern string, offset int) string {
+	var sb strings.Builder
+	for i, r := range []rune(pattern) {
+		if i == offset {
+			break
+		}
+		if r == '\t' {
+			sb.WriteRune('\t')
+		} else {
+			sb.WriteRune(' ')
+		}
+	}
+	return sb.String()
 }
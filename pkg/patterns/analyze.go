@@ -0,0 +1,544 @@
+package patterns
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"unicode"
+)
+
+// Severity says how serious a problem reported by Analyze is
+type Severity uint8
+
+const (
+	SeverityWarning Severity = iota // the pattern parses, but may be slow to match
+	SeverityError                   // the pattern does not parse
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Diagnostic describes a problem found in a pattern by Analyze
+type Diagnostic struct {
+	Severity Severity
+	Message  string
+	Offset   int    // offset in runes of the start of Fragment within the pattern
+	Fragment string // the part of the pattern that the problem is in
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s at offset %d: `%s`", d.Severity, d.Message, d.Offset, d.Fragment)
+}
+
+// Analyze looks for the parts of pattern that can make a backtracking matcher
+// take exponential or high polynomial time on input that almost matches,
+// which lets whoever supplies that input deny service (ReDoS). It warns about:
+//
+//   - a repeated element that can end with a repeat of text that could also
+//     start the element, so that the text can be shared out between the
+//     repetitions in many ways, as in (a+)+, (a+){2,1000} or ([a-z]+.)+,
+//     where the . could match what the repeat does
+//   - repeated alternatives that can match the same text, as in (\w|\d)+,
+//     or that together can match what another does, as in (a|aa)+
+//   - repeats in a row that can match the same characters, as in (.+)?.+
+//
+// The checks compare what elements can match, and so may miss problems that
+// depend on longer stretches of text, or warn about ones that the rest of the
+// pattern rules out. A pattern that does not parse gets a single diagnostic
+// with SeverityError. The options are as for ParsePattern.
+//
+// This package matches a pattern in linear time unless it needs backtracking,
+// such as for a backreference; see ParseOptions.MatchLimit for those that do.
+// The warnings matter for every pattern passed on to other matchers.
+func Analyze(pattern string, opts ...ParseOptions) []Diagnostic {
+	p, err := ParsePattern(pattern, opts...)
+	if err != nil {
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) {
+			return []Diagnostic{{
+				Severity: SeverityError,
+				Message:  string(parseErr.Code),
+				Offset:   parseErr.Offset,
+				Fragment: parseErr.Fragment,
+			}}
+		}
+		return []Diagnostic{{Severity: SeverityError, Message: err.Error()}}
+	}
+
+	a := &analyzer{pattern: []rune(pattern)}
+	a.sequence(p)
+	slices.SortStableFunc(a.diagnostics, func(x, y Diagnostic) int {
+		return x.Offset - y.Offset
+	})
+	return a.diagnostics
+}
+
+// analyzer collects the diagnostics for a parsed pattern
+type analyzer struct {
+	pattern     []rune
+	diagnostics []Diagnostic
+}
+
+func (a *analyzer) warn(source sourceRange, message string) {
+	a.diagnostics = append(a.diagnostics, Diagnostic{
+		Severity: SeverityWarning,
+		Message:  message,
+		Offset:   source.start,
+		Fragment: string(a.pattern[source.start:source.end]),
+	})
+}
+
+// sequence checks the elements of p, and each pattern inside them
+func (a *analyzer) sequence(p *Pattern) {
+	for i, element := range p.elements {
+		a.element(element, p.source[i])
+		a.adjacent(p, i)
+	}
+}
+
+// element checks a single element, found at source, and each pattern inside it
+func (a *analyzer) element(element PatternElement, source sourceRange) {
+	if inner, _, maxCount, mode, ok := quantifierBounds(element); ok {
+		// A possessive repeat never gives back what it matched, so the text
+		// cannot be shared out again
+		if (maxCount < 0 || maxCount > 1) && mode != possessive {
+			a.repeated(inner, source)
+		}
+		a.element(inner, source)
+		return
+	}
+
+	switch e := element.(type) {
+	case GroupMatcher:
+		a.sequence(e.pattern)
+	case AtomicGroupMatcher:
+		a.sequence(e.pattern)
+	case LookaroundMatcher:
+		a.sequence(e.pattern)
+	case AlternationMatcher:
+		for _, alt := range e.alternatives {
+			a.sequence(alt)
+		}
+	}
+}
+
+// repeated checks inner, the element of a repeat found at source, warning
+// about the first problem found
+func (a *analyzer) repeated(inner PatternElement, source sourceRange) {
+	first := firstRunes(inner)
+	for _, tail := range append(tailRepeats(inner), absorbingRepeats(inner)...) {
+		if firstRunes(tail).overlaps(first) {
+			a.warn(source, "nested repeats can share out the same text in exponentially many ways")
+			return
+		}
+	}
+
+	for _, alts := range leadingAlternations(inner) {
+		if overlappingAlternatives(alts, first) {
+			a.warn(source, "repeated alternatives can match the same text in exponentially many ways")
+			return
+		}
+	}
+}
+
+// adjacent checks whether the repeats that can end p.elements[i] can match
+// the same runes as those that can start a later element, with nothing
+// between them that must match
+func (a *analyzer) adjacent(p *Pattern, i int) {
+	tails := tailRepeats(p.elements[i])
+	if len(tails) == 0 {
+		return
+	}
+	for j := i + 1; j < len(p.elements); j++ {
+		for _, head := range headRepeats(p.elements[j]) {
+			for _, tail := range tails {
+				if firstRunes(head).overlaps(firstRunes(tail)) {
+					source := sourceRange{p.source[i].start, p.source[j].end}
+					a.warn(source, "repeats in a row can share out the same text in polynomially many ways")
+					return
+				}
+			}
+		}
+		if !nullable(p.elements[j]) {
+			return
+		}
+	}
+}
+
+// nullable reports whether element can match the empty string
+func nullable(element PatternElement) bool {
+	minWidth, _ := elementWidth(element)
+	return minWidth == 0
+}
+
+// tailRepeats returns the elements of the repeats of varying length that can match
+// the last runes matched by element. Possessive repeats and those in atomic
+// groups are left out, as what they match cannot be shared out differently.
+func tailRepeats(element PatternElement) []PatternElement {
+	return edgeRepeats(element, true)
+}
+
+// headRepeats returns the elements of the repeats of varying length that can match
+// the first runes matched by element
+func headRepeats(element PatternElement) []PatternElement {
+	return edgeRepeats(element, false)
+}
+
+func edgeRepeats(element PatternElement, tail bool) []PatternElement {
+	if inner, minCount, maxCount, mode, ok := quantifierBounds(element); ok {
+		if mode == possessive {
+			return nil
+		}
+		repeats := edgeRepeats(inner, tail)
+		if maxCount < 0 || maxCount > minCount {
+			repeats = append(repeats, inner)
+		}
+		return repeats
+	}
+
+	var patterns []*Pattern
+	switch e := element.(type) {
+	case GroupMatcher:
+		patterns = []*Pattern{e.pattern}
+	case AlternationMatcher:
+		patterns = e.alternatives
+	}
+	var repeats []PatternElement
+	for _, p := range patterns {
+		elements := p.elements
+		if tail {
+			elements = slices.Clone(elements)
+			slices.Reverse(elements)
+		}
+		for _, e := range elements {
+			repeats = append(repeats, edgeRepeats(e, tail)...)
+			if !nullable(e) {
+				break
+			}
+		}
+	}
+	return repeats
+}
+
+// absorbingRepeats returns the element of an unbounded repeat inside the group
+// element that is followed to the end of the group only by single runes that
+// it could also match, as [a-z]+ is in ([a-z]+.), so that when the group is
+// repeated, where one repetition ends and the next starts can shift
+func absorbingRepeats(element PatternElement) []PatternElement {
+	group, ok := element.(GroupMatcher)
+	if !ok {
+		return nil
+	}
+	var after []runeSet
+	for i := len(group.pattern.elements) - 1; i >= 0; i-- {
+		e := group.pattern.elements[i]
+		if isSingleRune(e) {
+			after = append(after, firstRunes(e))
+			continue
+		}
+		inner, _, maxCount, mode, ok := quantifierBounds(e)
+		if !ok || maxCount >= 0 || mode == possessive || len(after) == 0 {
+			return nil
+		}
+		set := firstRunes(inner)
+		for _, runes := range after {
+			if !runes.overlaps(set) {
+				return nil
+			}
+		}
+		return []PatternElement{inner}
+	}
+	return nil
+}
+
+// leadingAlternations returns the alternatives of element if it is an
+// alternation or, if it is a group, of each alternation among its elements,
+// looking inside nested groups too
+func leadingAlternations(element PatternElement) [][]*Pattern {
+	switch e := element.(type) {
+	case AlternationMatcher:
+		return [][]*Pattern{e.alternatives}
+	case GroupMatcher:
+		var alts [][]*Pattern
+		for _, inner := range e.pattern.elements {
+			alts = append(alts, leadingAlternations(inner)...)
+		}
+		return alts
+	}
+	return nil
+}
+
+// overlappingAlternatives reports whether alts, the alternatives of a repeated
+// element that can start with the runes in first, can match the same text in
+// more than one way. Alternatives that each match a fixed run of single runes
+// are compared rune by rune: two that can match the same text overlap, and so
+// do two where one can match the start of the other and the rest of the other
+// could start the next repetition, as in (a|aa)+ and (a|b|ab)+. Any others are
+// compared by the runes they can start with.
+func overlappingAlternatives(alts []*Pattern, first runeSet) bool {
+	for i, x := range alts {
+		for _, y := range alts[i+1:] {
+			xs, xFixed := fixedRunes(x)
+			ys, yFixed := fixedRunes(y)
+			if !xFixed || !yFixed {
+				if firstRunesOf(x.elements).overlaps(firstRunesOf(y.elements)) {
+					return true
+				}
+				continue
+			}
+			if len(xs) > len(ys) {
+				xs, ys = ys, xs
+			}
+			if len(xs) == 0 {
+				// An empty alternative only makes the others optional
+				continue
+			}
+			prefix := true
+			for k := 0; prefix && k < len(xs); k++ {
+				prefix = xs[k].overlaps(ys[k])
+			}
+			if prefix && (len(xs) == len(ys) || ys[len(xs)].overlaps(first)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fixedRunes returns the runes that each element of p can match, if every
+// element matches exactly one rune
+func fixedRunes(p *Pattern) ([]runeSet, bool) {
+	var sets []runeSet
+	for _, element := range p.elements {
+		if !isSingleRune(element) {
+			return nil, false
+		}
+		sets = append(sets, firstRunes(element))
+	}
+	return sets, true
+}
+
+// isSingleRune reports whether element always matches exactly one rune
+func isSingleRune(element PatternElement) bool {
+	switch element.(type) {
+	case GroupMatcher, AtomicGroupMatcher, AlternationMatcher, LinebreakMatcher,
+		LookaroundMatcher, BackReferenceMatcher, assertion:
+		return false
+	}
+	_, _, _, _, ok := quantifierBounds(element)
+	return !ok
+}
+
+// firstRunes returns the runes that element can start with
+func firstRunes(element PatternElement) runeSet {
+	if inner, _, _, _, ok := quantifierBounds(element); ok {
+		return firstRunes(inner)
+	}
+
+	switch e := element.(type) {
+	case GroupMatcher:
+		return firstRunesOf(e.pattern.elements)
+	case AtomicGroupMatcher:
+		return firstRunesOf(e.pattern.elements)
+	case AlternationMatcher:
+		var set runeSet
+		for _, alt := range e.alternatives {
+			set = set.union(firstRunesOf(alt.elements))
+		}
+		return set
+	case assertion, LookaroundMatcher, BackReferenceMatcher:
+		// Nothing is known about what a backreference matches
+		return nil
+	}
+	return matchedRunes(element)
+}
+
+// firstRunesOf returns the runes that a sequence of elements can start with
+func firstRunesOf(elements []PatternElement) runeSet {
+	var set runeSet
+	for _, element := range elements {
+		set = set.union(firstRunes(element))
+		if !nullable(element) {
+			break
+		}
+	}
+	return set
+}
+
+// verticalSpace holds the runes matched by \v
+var verticalSpace = runeSet{{'\n', '\r'}, {'\u0085', '\u0085'}, {'\u2028', '\u2029'}}
+
+// matchedRunes returns the runes that element, which matches a single rune,
+// can match. The classes are taken from the Unicode tables that they are
+// defined by, so that overlaps are found wherever they are.
+func matchedRunes(element PatternElement) runeSet {
+	switch e := element.(type) {
+	case LiteralMatcher:
+		set := runeSet{{e.char, e.char}}
+		if e.caseless {
+			for f := unicode.SimpleFold(e.char); f != e.char; f = unicode.SimpleFold(f) {
+				set = set.union(runeSet{{f, f}})
+			}
+		}
+		return set
+	case DigitMatcher:
+		return tableRunes(unicode.Nd).negate(e.negated)
+	case AlphanumericMatcher:
+		return tableRunes(unicode.L).union(tableRunes(unicode.Nd)).union(runeSet{{'_', '_'}}).negate(e.negated)
+	case WhitespaceMatcher:
+		return tableRunes(unicode.White_Space).negate(e.negated)
+	case HorizontalSpaceMatcher:
+		return tableRunes(unicode.Zs).union(runeSet{{'\t', '\t'}, {'\u180e', '\u180e'}}).negate(e.negated)
+	case VerticalSpaceMatcher:
+		return verticalSpace.negate(e.negated)
+	case LinebreakMatcher:
+		return verticalSpace
+	case WildcardMatcher:
+		if e.dotAll {
+			return runeSet{{0, unicode.MaxRune}}
+		}
+		return runeSet{{'\n', '\n'}}.negate(true)
+	case UnicodeClassMatcher:
+		if e.table == nil {
+			return runeSet{{0, unicode.MaxRune}}.negate(e.negated)
+		}
+		return tableRunes(e.table).negate(e.negated)
+	case CharacterSetMatcher:
+		set := runeSet(e.ranges)
+		for _, class := range e.classes {
+			set = set.union(matchedRunes(class))
+		}
+		if e.caseless {
+			// Add every rune with another case in the set
+			var folded runeSet
+			for _, r := range foldableRunes() {
+				for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+					if set.contains(f) {
+						folded = append(folded, runeRange{r, r})
+						break
+					}
+				}
+			}
+			set = set.union(folded)
+		}
+		return set.negate(e.negated)
+	case PosixClassMatcher:
+		// The Unicode-aware classes are predicates, so are worked out once
+		if set, ok := posixRunes.Load(e.name); ok {
+			return set.(runeSet)
+		}
+		set := scanRunes(e.Match)
+		posixRunes.Store(e.name, set)
+		return set
+	}
+	return scanRunes(element.Match)
+}
+
+// posixRunes caches the runes matched by each Unicode-aware POSIX class, by name
+var posixRunes sync.Map
+
+// foldableRunes lists, in order, the runes that have another case under
+// simple case folding
+var foldableRunes = sync.OnceValue(func() []rune {
+	var runes []rune
+	for r := rune(0); r <= unicode.MaxRune; r++ {
+		if unicode.SimpleFold(r) != r {
+			runes = append(runes, r)
+		}
+	}
+	return runes
+})
+
+// scanRunes returns the runes for which match is true, trying every one
+func scanRunes(match func(r rune) bool) runeSet {
+	var set runeSet
+	for r := rune(0); r <= unicode.MaxRune; r++ {
+		if !match(r) {
+			continue
+		}
+		if n := len(set); n > 0 && set[n-1].hi == r-1 {
+			set[n-1].hi = r
+		} else {
+			set = append(set, runeRange{r, r})
+		}
+	}
+	return set
+}
+
+// tableRunes returns the runes in table
+func tableRunes(table *unicode.RangeTable) runeSet {
+	var ranges []runeRange
+	add := func(lo, hi, stride rune) {
+		if stride == 1 {
+			ranges = append(ranges, runeRange{lo, hi})
+			return
+		}
+		for r := lo; r <= hi; r += stride {
+			ranges = append(ranges, runeRange{r, r})
+		}
+	}
+	for _, r := range table.R16 {
+		add(rune(r.Lo), rune(r.Hi), rune(r.Stride))
+	}
+	for _, r := range table.R32 {
+		add(rune(r.Lo), rune(r.Hi), rune(r.Stride))
+	}
+	return mergeRanges(ranges)
+}
+
+// runeSet is a set of runes, held as sorted ranges that neither overlap nor
+// touch
+type runeSet []runeRange
+
+func (s runeSet) union(t runeSet) runeSet {
+	if len(t) == 0 {
+		return s
+	}
+	return mergeRanges(append(slices.Clip(s), t...))
+}
+
+func (s runeSet) overlaps(t runeSet) bool {
+	for i, j := 0, 0; i < len(s) && j < len(t); {
+		switch {
+		case s[i].hi < t[j].lo:
+			i++
+		case t[j].hi < s[i].lo:
+			j++
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func (s runeSet) contains(r rune) bool {
+	i := sort.Search(len(s), func(i int) bool {
+		return s[i].hi >= r
+	})
+	return i < len(s) && s[i].lo <= r
+}
+
+// negate returns the runes that are not in s if negated is set, or else s
+func (s runeSet) negate(negated bool) runeSet {
+	if !negated {
+		return s
+	}
+	var complement runeSet
+	next := rune(0)
+	for _, rr := range s {
+		if rr.lo > next {
+			complement = append(complement, runeRange{next, rr.lo - 1})
+		}
+		next = rr.hi + 1
+	}
+	if next <= unicode.MaxRune {
+		complement = append(complement, runeRange{next, unicode.MaxRune})
+	}
+	return complement
+}
@@ -0,0 +1,70 @@
+package patterns
+
+import "testing"
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		pattern string
+		warn    bool
+	}{
+		// Known ReDoS patterns
+		{`(a+)+b`, true},
+		{`(a*)*b`, true},
+		{`(a|a)*b`, true},
+		{`(a|aa)+b`, true},
+		{`(?:a|b|ab)*c`, true},
+		{`^(([a-z])+.)+[A-Z]([a-z])+$`, true},
+		{`(a+){2,1000}`, true},
+		{`(\p{Greek}|\p{Ll})+`, true},
+		{`(\w|\d)+$`, true},
+		{`(.*a){12}`, true},
+		{`^(\w+\s?)*$`, true},
+		{`(x+x+)+y`, true},
+		{`(?i)(k|\x{212a})+$`, true},
+		{`([[:alpha:]]|é)+$`, true},
+		{`(\s|\x{3000})+$`, true},
+		{`\d+\d+$`, true},
+		{`(.+)?.+$`, true},
+		{`(\d?\d?)+$`, true},
+		{`(\d{0,2})+$`, true},
+		{`(a?){20}a{20}`, true},
+		{`(a?a?)*b`, true},
+
+		// Safe patterns
+		{`a+b`, false},
+		{`(ab)+c`, false},
+		{`(a|b)+c`, false},
+		{`(a|ab)+c`, false},
+		{`(ab|ac)+d`, false},
+		{`(a+)++b`, false},
+		{`(?>a+)+b`, false},
+		{`(\p{Greek}|\p{Latin})+`, false},
+		{`(\d+\.){3}\d+`, false},
+		{`(a+){1}b`, false},
+		{`[a-z]+@[a-z]+\.com`, false},
+		{`\d+\s\d+`, false},
+		{`(\s|\S)+`, false},
+		{`(ab?)+c`, false},
+		{`-?\d+(\.\d+)?`, false},
+		{`(a?b)+$`, false},
+	}
+	for _, tt := range tests {
+		diagnostics := Analyze(tt.pattern)
+		if got := len(diagnostics) > 0; got != tt.warn {
+			t.Errorf("Analyze(%q): got %v, want a warning: %v", tt.pattern, diagnostics, tt.warn)
+		}
+		for _, d := range diagnostics {
+			if d.Severity != SeverityWarning {
+				t.Errorf("Analyze(%q): got %v, want only warnings", tt.pattern, d)
+			}
+		}
+	}
+}
+
+func TestAnalyzeParseError(t *testing.T) {
+	diagnostics := Analyze(`a(b`)
+	if len(diagnostics) != 1 || diagnostics[0].Severity != SeverityError ||
+		diagnostics[0].Message != string(ErrMissingParen) || diagnostics[0].Offset != 1 {
+		t.Errorf("got %v, want a single error for the missing )", diagnostics)
+	}
+}
@@ -0,0 +1,353 @@
+package patterns
+
+import "slices"
+
+// backtracker executes a program by following one path through it at a time,
+// taking the preferred branch at each split and going back to the most recent
+// untried one when the path fails. Unlike the Pike VM it can run every
+// instruction, including backreferences and the bodies of atomic groups and
+// lookarounds, and it finds the same match as the Pike VM for programs both
+// can run.
+//
+// A pc and position from which the program has already failed are not tried
+// again, which bounds the running time by O(len(program) * len(input)) as for
+// the Pike VM. That does not hold once backreferences make the outcome depend
+// on what was captured on the way, so then a path is only stopped from going
+// round a loop without consuming any input.
+type backtracker struct {
+	prog    *program
+	input   []rune
+	longest bool // keep looking for a longer match from the same start once one is found
+	history bool // whether to keep a log of every capture
+	limit   *matchLimit
+
+	// caps holds the capture slots of the current path, followed by the
+	// position at which each group was last entered; a group's slots are
+	// only set once it has matched, so that a backreference inside it sees
+	// what it captured in the previous iteration
+	caps []int
+	hist *captureLog
+	jobs []job
+	best thread // longest match found so far from the current start
+
+	// visited holds the pcs and positions that have been tried, or is nil
+	// when the program has backreferences
+	visited *visitSet
+	// touched lists the pcs and positions added to visited while trying the
+	// body of an atomic group or lookaround. They are taken out again once
+	// the body is done, as having failed from somewhere in one attempt says
+	// nothing about the next, which may start elsewhere or need to end
+	// elsewhere.
+	touched []int
+	depth   int // number of bodies being tried
+	// loops holds, for the pc of each split, the position at which the
+	// current path last went through it and the generation it did so in;
+	// it is only used when visited is nil
+	loops []loopEntry
+	// gen is the current generation for loops. The body of an atomic group
+	// or lookaround gets a new one each time it is tried.
+	gen, gens uint32
+}
+
+// jobKind identifies what a job on the backtracker's stack does
+type jobKind uint8
+
+const (
+	jobTry         jobKind = iota // follow the path from pc at pos
+	jobRestoreCap                 // set capture slot pc back to pos, and the log back to hist
+	jobRestoreCaps                // set every capture slot back to caps, and the log back to hist
+	jobRestoreLoop                // set the loop entry for pc back to loop
+)
+
+// job is a branch left to try, or a change to undo when backtracking past it
+type job struct {
+	kind    jobKind
+	pc, pos int
+	caps    []int
+	hist    *captureLog
+	loop    loopEntry
+}
+
+// loopEntry records when the current path last went through a split
+type loopEntry struct {
+	pos int
+	gen uint32
+}
+
+func newBacktracker(prog *program, input []rune, longest bool) *backtracker {
+	b := &backtracker{
+		prog:    prog,
+		input:   input,
+		longest: longest,
+		gen:     1,
+		gens:    1,
+	}
+	if !prog.backrefs {
+		b.visited = newVisitSet(len(prog.insts) * (len(input) + 1))
+	} else {
+		b.loops = make([]loopEntry, len(prog.insts))
+	}
+	return b
+}
+
+// run returns the thread of the leftmost match that starts at or after start.
+// It prefers the same match as pikeVM.run or, with longest set, the longest
+// of the leftmost matches, capturing as the first path to reach its end in
+// order of preference does. Its caps are nil if there is no match, or if the
+// limit stopped the search.
+func (b *backtracker) run(start int) thread {
+	// A pattern anchored to the start of the input can only match there
+	lastStart := len(b.input)
+	if in := b.prog.insts[1]; in.op == instEmpty && emptyOp(in.arg)&emptyBeginText != 0 {
+		lastStart = 0
+	}
+
+	for pos := start; pos <= lastStart; pos++ {
+		b.caps = newCaptures(b.prog.numCap + b.prog.numCap/2)
+		b.hist = nil
+		_, ok := b.try(0, pos, -1)
+		if b.limit.stopped() {
+			return thread{}
+		}
+		if ok && !b.longest {
+			return thread{caps: b.caps[:b.prog.numCap], hist: b.hist}
+		}
+		if b.best.caps != nil {
+			return b.best
+		}
+	}
+	return thread{}
+}
+
+// try follows the program from pc at pos, backtracking as needed, until it
+// reaches an instMatch, or an instSucceed at want unless want is negative. It
+// returns the position reached there, leaving the captures as the path that
+// got there set them, or false if no path does. With longest set, reaching an
+// instMatch only records the match in best, and the search goes on.
+func (b *backtracker) try(pc, pos, want int) (int, bool) {
+	base := len(b.jobs)
+	b.jobs = append(b.jobs, job{kind: jobTry, pc: pc, pos: pos})
+	for len(b.jobs) > base {
+		j := b.jobs[len(b.jobs)-1]
+		b.jobs = b.jobs[:len(b.jobs)-1]
+		switch j.kind {
+		case jobRestoreCap:
+			b.caps[j.pc], b.hist = j.pos, j.hist
+			continue
+		case jobRestoreCaps:
+			copy(b.caps, j.caps)
+			b.hist = j.hist
+			continue
+		case jobRestoreLoop:
+			b.loops[j.pc] = j.loop
+			continue
+		}
+
+		pc, pos := j.pc, j.pos
+	path:
+		for {
+			if !b.limit.step() {
+				b.jobs = b.jobs[:base]
+				return 0, false
+			}
+			if b.visited != nil {
+				i := pos*len(b.prog.insts) + pc
+				if b.visited.has(i) {
+					break
+				}
+				b.visited.add(i)
+				if b.depth > 0 {
+					b.touched = append(b.touched, i)
+				}
+			}
+
+			in := &b.prog.insts[pc]
+			switch in.op {
+			case instRune:
+				if pos >= len(b.input) || !in.elem.Match(b.input[pos]) {
+					break path
+				}
+				pc, pos = pc+1, pos+1
+
+			case instSplit:
+				if b.visited == nil {
+					// Going round a loop without consuming input would never end
+					entry := loopEntry{pos: pos, gen: b.gen}
+					if b.loops[pc] == entry {
+						break path
+					}
+					b.jobs = append(b.jobs, job{kind: jobRestoreLoop, pc: pc, loop: b.loops[pc]})
+					b.loops[pc] = entry
+				}
+				b.jobs = append(b.jobs, job{kind: jobTry, pc: in.y, pos: pos})
+				pc = in.x
+
+			case instJmp:
+				pc = in.x
+
+			case instSave:
+				b.save(in.arg, pos)
+				pc++
+
+			case instEmpty:
+				if emptyOp(in.arg)&^emptyOpAt(b.input, pos) != 0 {
+					break path
+				}
+				pc++
+
+			case instBackref:
+				start, end := b.caps[2*in.arg], b.caps[2*in.arg+1]
+				if start < 0 {
+					// The group has not captured anything, not even the empty string
+					break path
+				}
+				next, ok := in.elem.(BackReferenceMatcher).matchAt(b.input, pos, b.input[start:end])
+				if !ok {
+					break path
+				}
+				pc, pos = pc+1, next
+
+			case instAtomic:
+				saved, hist := slices.Clone(b.caps), b.hist
+				end, ok := b.body(pc+1, pos, -1)
+				if !ok {
+					break path
+				}
+				// Backtracking past the group undoes what it captured, but
+				// never tries another way through it
+				b.jobs = append(b.jobs, job{kind: jobRestoreCaps, caps: saved, hist: hist})
+				pc, pos = in.x, end
+
+			case instLook:
+				if !b.look(in.elem.(LookaroundMatcher), pc+1, pos) {
+					break path
+				}
+				pc = in.x
+
+			case instSucceed:
+				if want >= 0 && pos != want {
+					break path
+				}
+				b.jobs = b.jobs[:base]
+				return pos, true
+
+			case instMatch:
+				if !b.longest {
+					b.jobs = b.jobs[:base]
+					return pos, true
+				}
+				if b.best.caps == nil || pos > b.best.caps[1] {
+					b.best = thread{caps: slices.Clone(b.caps[:b.prog.numCap]), hist: b.hist}
+				}
+				if pos == len(b.input) {
+					// Nothing can be longer
+					b.jobs = b.jobs[:base]
+					return pos, true
+				}
+				break path
+			}
+		}
+	}
+	return 0, false
+}
+
+// save records pos in capture slot arg. The start of a group is kept aside
+// until the group ends, when both its slots are set.
+func (b *backtracker) save(arg, pos int) {
+	slot := arg
+	switch {
+	case arg < 2:
+	case arg%2 == 0:
+		slot = b.prog.numCap + arg/2
+	default:
+		b.jobs = append(b.jobs, job{kind: jobRestoreCap, pc: arg - 1, pos: b.caps[arg-1], hist: b.hist})
+		b.caps[arg-1] = b.caps[b.prog.numCap+arg/2]
+	}
+	b.jobs = append(b.jobs, job{kind: jobRestoreCap, pc: slot, pos: b.caps[slot], hist: b.hist})
+	b.caps[slot] = pos
+	if b.history {
+		b.hist = record(b.hist, b.caps, arg)
+	}
+}
+
+// body tries the body of an instAtomic or instLook, which starts at pc, from
+// pos in a generation of its own
+func (b *backtracker) body(pc, pos, want int) (int, bool) {
+	gen, mark := b.gen, len(b.touched)
+	b.gens++
+	b.gen = b.gens
+	b.depth++
+	defer func() {
+		for _, i := range b.touched[mark:] {
+			b.visited.remove(i)
+		}
+		b.touched = b.touched[:mark]
+		b.gen = gen
+		b.depth--
+	}()
+	return b.try(pc, pos, want)
+}
+
+// visitChunkWords is the number of words in each chunk of a visitSet
+const visitChunkWords = 512
+
+// visitSet is a set of small integers held as a bitset. Its chunks are only
+// allocated once something in them is added, so that a search that gives up
+// or matches early over a long input does not pay for all of it.
+type visitSet struct {
+	chunks []*[visitChunkWords]uint64
+}
+
+// newVisitSet returns an empty set that can hold the integers below n
+func newVisitSet(n int) *visitSet {
+	const chunkBits = visitChunkWords * 64
+	return &visitSet{chunks: make([]*[visitChunkWords]uint64, (n+chunkBits-1)/chunkBits)}
+}
+
+func (s *visitSet) has(i int) bool {
+	chunk := s.chunks[i/(visitChunkWords*64)]
+	return chunk != nil && chunk[i/64%visitChunkWords]&(1<<(i%64)) != 0
+}
+
+func (s *visitSet) add(i int) {
+	chunk := s.chunks[i/(visitChunkWords*64)]
+	if chunk == nil {
+		chunk = new([visitChunkWords]uint64)
+		s.chunks[i/(visitChunkWords*64)] = chunk
+	}
+	chunk[i/64%visitChunkWords] |= 1 << (i % 64)
+}
+
+func (s *visitSet) remove(i int) {
+	if chunk := s.chunks[i/(visitChunkWords*64)]; chunk != nil {
+		chunk[i/64%visitChunkWords] &^= 1 << (i % 64)
+	}
+}
+
+// look reports whether the lookaround e, whose body starts at pc, holds at
+// pos. What the body of a positive lookaround captured stays set until the
+// path backtracks past it; a negative lookaround captures nothing.
+func (b *backtracker) look(e LookaroundMatcher, pc, pos int) bool {
+	saved, hist := slices.Clone(b.caps), b.hist
+	matched := false
+	if e.behind {
+		// Try every start that leaves room for the body, requiring it to end at pos
+		for start := max(pos-e.maxLen, 0); start <= pos-e.minLen && !matched && !b.limit.stopped(); start++ {
+			_, matched = b.body(pc, start, pos)
+		}
+	} else {
+		_, matched = b.body(pc, pos, -1)
+	}
+	if b.limit.stopped() {
+		return false
+	}
+
+	switch {
+	case matched && e.negated:
+		copy(b.caps, saved)
+		b.hist = hist
+	case matched:
+		b.jobs = append(b.jobs, job{kind: jobRestoreCaps, caps: saved, hist: hist})
+	}
+	return matched != e.negated
+}
@@ -0,0 +1,27 @@
+package patterns
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBacktrackerLinear checks that patterns which can only be run by the
+// backtracker, but have no backreferences, still fail in linear time over a
+// long input that would make a plain backtracker take exponential time
+func TestBacktrackerLinear(t *testing.T) {
+	input := []rune(strings.Repeat("a", 100000))
+	for _, pattern := range []string{`(?!z)(a|a)*b`, `x*+(a|aa)*b`, `(?=a)(a+)+b`, `(?<=a|^)(a|aa)*c`, `(?>a|b)(a*)*b`} {
+		p, err := ParsePattern(pattern)
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", pattern, err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		matched, err := p.MatchContext(ctx, input)
+		cancel()
+		if err != nil || matched {
+			t.Errorf("%q: got %v, %v, want false, nil", pattern, matched, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package patterns
+
+import (
+	"cmp"
+	"slices"
+	"sort"
+	"unicode"
+)
+
+// runeRange is an inclusive range of runes
+type runeRange struct {
+	lo, hi rune
+}
+
+// CharacterSetMatcher matches any character in a bracket expression. Single
+// runes and ranges are kept as a sorted table of non-overlapping ranges that is
+// binary searched; classes such as \d written inside the brackets are checked
+// afterwards. A caseless set also matches the other cases of its members.
+type CharacterSetMatcher struct {
+	ranges   []runeRange
+	classes  []PatternElement
+	negated  bool
+	caseless bool
+}
+
+// newCharacterSet builds a CharacterSetMatcher, sorting ranges and merging
+// any that overlap or touch
+func newCharacterSet(ranges []runeRange, classes []PatternElement, negated bool) CharacterSetMatcher {
+	return CharacterSetMatcher{ranges: mergeRanges(ranges), classes: classes, negated: negated}
+}
+
+// mergeRanges returns ranges sorted, with any that overlap or touch merged
+func mergeRanges(ranges []runeRange) []runeRange {
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b runeRange) int {
+		return cmp.Compare(a.lo, b.lo)
+	})
+
+	var merged []runeRange
+	for _, rr := range sorted {
+		if n := len(merged); n > 0 && rr.lo <= merged[n-1].hi+1 {
+			merged[n-1].hi = max(merged[n-1].hi, rr.hi)
+			continue
+		}
+		merged = append(merged, rr)
+	}
+	return merged
+}
+
+func (m CharacterSetMatcher) Match(r rune) bool {
+	if m.caseless {
+		// A rune is in the set if any rune in its case folding orbit is
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if m.contains(f) {
+				return !m.negated
+			}
+		}
+	}
+	return m.contains(r) != m.negated
+}
+
+// contains reports whether r is a member of the set, ignoring negation
+func (m CharacterSetMatcher) contains(r rune) bool {
+	// Find the first range that ends at or after r
+	i := sort.Search(len(m.ranges), func(i int) bool {
+		return m.ranges[i].hi >= r
+	})
+	if i < len(m.ranges) && m.ranges[i].lo <= r {
+		return true
+	}
+
+	for _, class := range m.classes {
+		if class.Match(r) {
+			return true
+		}
+	}
+	return false
+}
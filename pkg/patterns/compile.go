@@ -0,0 +1,338 @@
+package patterns
+
+// instOp identifies the kind of a compiled instruction
+type instOp uint8
+
+const (
+	instRune  instOp = iota // consume one rune accepted by elem, then continue at pc+1
+	instSplit               // fork: try x first, then y
+	instJmp                 // continue at x
+	instSave                // record the current position in capture slot arg, then continue at pc+1
+	instEmpty               // zero-width assertion on the conditions in arg, then continue at pc+1
+	instMatch               // the whole pattern has matched
+
+	// The instructions below can only be run by the backtracker
+	instBackref // match the text captured by group arg again, as elem says, then continue at pc+1
+	instAtomic  // match the body from pc+1 once, committing to its first match, then continue at x
+	instLook    // test the body from pc+1 as the lookaround in elem says, then continue at x
+	instSucceed // the body of an instAtomic or instLook has matched
+)
+
+// emptyOp is a set of zero-width conditions that can hold at a position in the input
+type emptyOp uint8
+
+const (
+	emptyBeginText      emptyOp = 1 << iota // at the start of the input
+	emptyEndText                            // at the end of the input
+	emptyWordBoundary                       // between a word character and a non-word character or the edge of the input
+	emptyNoWordBoundary                     // anywhere that is not a word boundary
+	emptyBeginLine                          // at the start of the input or after a newline
+	emptyEndLine                            // at the end of the input or before a newline
+	emptyEndTextNewline                     // at the end of the input or before a newline that ends it
+	emptyNotMidCRLF                         // anywhere except between the \r and \n of a CRLF pair
+)
+
+// emptyOpAt returns the zero-width conditions that hold at pos in input
+func emptyOpAt(input []rune, pos int) emptyOp {
+	before, after := rune(-1), rune(-1)
+	if pos > 0 {
+		before = input[pos-1]
+	}
+	if pos < len(input) {
+		after = input[pos]
+	}
+	op := emptyOpContext(before, after)
+	if pos == len(input) || pos == len(input)-1 && after == '\n' {
+		op |= emptyEndTextNewline
+	}
+	return op
+}
+
+// emptyOpContext returns the zero-width conditions that hold between the runes
+// before and after a position, where -1 stands for the start or the end of the
+// input. emptyEndTextNewline is left out as it depends on what follows after.
+func emptyOpContext(before, after rune) emptyOp {
+	var op emptyOp
+	if before < 0 {
+		op |= emptyBeginText
+	}
+	if after < 0 {
+		op |= emptyEndText
+	}
+	if before < 0 || before == '\n' {
+		op |= emptyBeginLine
+	}
+	if after < 0 || after == '\n' {
+		op |= emptyEndLine
+	}
+	return op | wordBoundaryOp(isWordChar(before), isWordChar(after)) | crlfOp(before == '\r', after == '\n')
+}
+
+// wordBoundaryOp returns the word boundary condition that holds between two
+// runes, given whether each is a word character
+func wordBoundaryOp(beforeWord, afterWord bool) emptyOp {
+	if beforeWord != afterWord {
+		return emptyWordBoundary
+	}
+	return emptyNoWordBoundary
+}
+
+// crlfOp returns the CRLF condition that holds between two runes, given
+// whether the one before is \r and the one after is \n
+func crlfOp(beforeCR, afterLF bool) emptyOp {
+	if beforeCR && afterLF {
+		return 0
+	}
+	return emptyNotMidCRLF
+}
+
+// isWordChar reports whether r is a word character for \b and \B; -1, used
+// for the edges of the input, is not
+func isWordChar(r rune) bool {
+	return r >= 0 && AlphanumericMatcher{}.Match(r)
+}
+
+// inst is a single instruction of a compiled program
+type inst struct {
+	op   instOp
+	x, y int            // branch targets for instSplit and instJmp, and the continuation of instAtomic and instLook
+	arg  int            // capture slot for instSave, emptyOp for instEmpty, group for instBackref
+	elem PatternElement // rune predicate for instRune, and the matcher behind instBackref and instLook
+}
+
+// program is a Pattern compiled to a Thompson NFA, laid out as a list of
+// instructions. Execution always starts at pc 0.
+type program struct {
+	insts  []inst
+	numCap int // number of capture slots: a start and end slot for the whole match and for each group
+
+	// finalNewline is set if the program tests emptyEndTextNewline, which
+	// the lazy DFA cannot tell from the next rune alone
+	finalNewline bool
+	// backtrack is set if the program uses an instruction that only the
+	// backtracker can run, for a backreference, a possessive quantifier, an
+	// atomic group or a lookaround
+	backtrack bool
+	// backrefs is set if the program has an instBackref, which makes whether
+	// it matches from a pc and position depend on the path taken there
+	backrefs bool
+}
+
+// compiler turns a parsed Pattern into a program
+type compiler struct {
+	prog *program
+}
+
+// compile translates p into a program
+func compile(p *Pattern) *program {
+	c := &compiler{prog: &program{numCap: 2 * (p.groupCount + 1)}}
+	c.emit(inst{op: instSave, arg: 0})
+	c.pattern(p)
+	c.emit(inst{op: instSave, arg: 1})
+	c.emit(inst{op: instMatch})
+	return c.prog
+}
+
+// emit appends i to the program and returns its pc
+func (c *compiler) emit(i inst) int {
+	c.prog.insts = append(c.prog.insts, i)
+	return len(c.prog.insts) - 1
+}
+
+// pc returns the pc of the next instruction to be emitted
+func (c *compiler) pc() int {
+	return len(c.prog.insts)
+}
+
+func (c *compiler) pattern(p *Pattern) {
+	for _, element := range p.elements {
+		c.element(element)
+	}
+}
+
+func (c *compiler) element(element PatternElement) {
+	switch e := element.(type) {
+	case GroupMatcher:
+		if e.index == 0 {
+			c.pattern(e.pattern)
+			break
+		}
+		c.emit(inst{op: instSave, arg: 2 * e.index})
+		c.pattern(e.pattern)
+		c.emit(inst{op: instSave, arg: 2*e.index + 1})
+
+	case AlternationMatcher:
+		// Each alternative but the last is guarded by a split preferring it
+		// over the ones that follow, and jumps past the rest when it matches
+		var jumps []int
+		for i, alt := range e.alternatives {
+			if i == len(e.alternatives)-1 {
+				c.pattern(alt)
+				break
+			}
+			split := c.emit(inst{op: instSplit})
+			c.prog.insts[split].x = c.pc()
+			c.pattern(alt)
+			jumps = append(jumps, c.emit(inst{op: instJmp}))
+			c.prog.insts[split].y = c.pc()
+		}
+		for _, j := range jumps {
+			c.prog.insts[j].x = c.pc()
+		}
+
+	case OneOrMoreMatcher, ZeroOrOneMatcher, ZeroOrMoreMatcher, RepeatMatcher:
+		inner, minCount, maxCount, mode, _ := quantifierBounds(element)
+		if mode == possessive {
+			// x*+ is (?>x*)
+			c.body(instAtomic, nil, func() { c.repeat(inner, minCount, maxCount, greedy) })
+			break
+		}
+		c.repeat(inner, minCount, maxCount, mode)
+
+	case AtomicGroupMatcher:
+		c.body(instAtomic, nil, func() { c.pattern(e.pattern) })
+
+	case LookaroundMatcher:
+		c.body(instLook, e, func() { c.pattern(e.pattern) })
+
+	case BackReferenceMatcher:
+		c.prog.backtrack, c.prog.backrefs = true, true
+		c.emit(inst{op: instBackref, arg: e.index, elem: e})
+
+	case assertion:
+		op := e.emptyOp()
+		if op&emptyEndTextNewline != 0 {
+			c.prog.finalNewline = true
+		}
+		c.emit(inst{op: instEmpty, arg: int(op)})
+
+	case LinebreakMatcher:
+		// \r\n is tried before a single vertical space, which may not be the
+		// \r of a CRLF pair, so a CRLF pair is always one line break: \R is
+		// atomic, as in PCRE, without needing the backtracker
+		split := c.emit(inst{op: instSplit})
+		c.prog.insts[split].x = c.pc()
+		c.emit(inst{op: instRune, elem: LiteralMatcher{char: '\r'}})
+		c.emit(inst{op: instRune, elem: LiteralMatcher{char: '\n'}})
+		jmp := c.emit(inst{op: instJmp})
+		c.prog.insts[split].y = c.pc()
+		c.emit(inst{op: instRune, elem: e})
+		c.emit(inst{op: instEmpty, arg: int(emptyNotMidCRLF)})
+		c.prog.insts[jmp].x = c.pc()
+
+	default:
+		c.emit(inst{op: instRune, elem: element})
+	}
+}
+
+// compiledSize returns the number of instructions that element compiles to
+func compiledSize(element PatternElement) int {
+	switch e := element.(type) {
+	case GroupMatcher:
+		if e.index == 0 {
+			return e.pattern.compiledSize()
+		}
+		return e.pattern.compiledSize() + 2
+	case AlternationMatcher:
+		size := 2 * (len(e.alternatives) - 1)
+		for _, alt := range e.alternatives {
+			size += alt.compiledSize()
+		}
+		return size
+	case OneOrMoreMatcher, ZeroOrOneMatcher, ZeroOrMoreMatcher, RepeatMatcher:
+		inner, minCount, maxCount, mode, _ := quantifierBounds(element)
+		size := compiledSize(inner)
+		switch {
+		case maxCount < 0 && minCount > 0:
+			size = minCount*size + 1
+		case maxCount < 0:
+			size += 2
+		default:
+			size = maxCount*size + maxCount - minCount
+		}
+		if mode == possessive {
+			size += 2
+		}
+		return size
+	case AtomicGroupMatcher:
+		return e.pattern.compiledSize() + 2
+	case LookaroundMatcher:
+		return e.pattern.compiledSize() + 2
+	case LinebreakMatcher:
+		return 6
+	default:
+		return 1
+	}
+}
+
+// compiledSize returns the number of instructions that the elements of p
+// compile to
+func (p *Pattern) compiledSize() int {
+	size := 0
+	for _, element := range p.elements {
+		size += compiledSize(element)
+	}
+	return size
+}
+
+// body emits an instAtomic or instLook, given by op, followed by the body
+// emitted by emitBody and an instSucceed to end it
+func (c *compiler) body(op instOp, elem PatternElement, emitBody func()) {
+	c.prog.backtrack = true
+	pc := c.emit(inst{op: op, elem: elem})
+	emitBody()
+	c.emit(inst{op: instSucceed})
+	c.prog.insts[pc].x = c.pc()
+}
+
+// repeat emits element repeated between minCount and maxCount times, with no
+// upper bound if maxCount is negative. The mandatory copies come first,
+// followed by either a loop or a chain of nested optional copies, so x{2,4}
+// becomes xx(x(x)?)? and x{2,} becomes xx+.
+func (c *compiler) repeat(element PatternElement, minCount, maxCount int, mode quantifierMode) {
+	if maxCount < 0 && minCount > 0 {
+		// Loop back over the last mandatory copy rather than emitting another
+		for n := 0; n < minCount-1; n++ {
+			c.element(element)
+		}
+		start := c.pc()
+		c.element(element)
+		split := c.emit(inst{op: instSplit})
+		c.patchSplit(split, start, c.pc(), mode)
+		return
+	}
+
+	for n := 0; n < minCount; n++ {
+		c.element(element)
+	}
+	if maxCount < 0 {
+		// x* becomes (x+)? so that a first iteration matching the empty
+		// string still counts, setting any groups inside it
+		split := c.emit(inst{op: instSplit})
+		body := c.pc()
+		c.element(element)
+		loop := c.emit(inst{op: instSplit})
+		c.patchSplit(loop, body, c.pc(), mode)
+		c.patchSplit(split, body, c.pc(), mode)
+		return
+	}
+
+	var splits, bodies []int
+	for n := minCount; n < maxCount; n++ {
+		splits = append(splits, c.emit(inst{op: instSplit}))
+		bodies = append(bodies, c.pc())
+		c.element(element)
+	}
+	for n, split := range splits {
+		c.patchSplit(split, bodies[n], c.pc(), mode)
+	}
+}
+
+// patchSplit points the split at pc to body and next, preferring body (one
+// more repetition) unless the quantifier is lazy
+func (c *compiler) patchSplit(pc, body, next int, mode quantifierMode) {
+	if mode == lazy {
+		body, next = next, body
+	}
+	c.prog.insts[pc].x, c.prog.insts[pc].y = body, next
+}
@@ -0,0 +1,207 @@
+package patterns
+
+import (
+	"slices"
+	"sync"
+)
+
+// dfaCacheSize bounds the number of DFA states and transitions kept in memory
+// at once. A state can have a transition for every distinct rune in the input,
+// so transitions count as much as states do. When the cache is full it is
+// flushed and states are rebuilt on demand.
+const dfaCacheSize = 1 << 16
+
+// lazyDFA answers whether a program matches an input without tracking
+// captures. Each DFA state is the set of NFA threads alive between two runes;
+// states and their transitions are only built when the input first reaches
+// them, and are then reused by later matches.
+//
+// A Pattern may be shared between goroutines, so rather than lock the states
+// for the whole of a match, each match takes a dfaCache of its own from a
+// pool, as package regexp does with its machines. Goroutines matching at once
+// then build their states separately, and later matches reuse whichever cache
+// they are given.
+type lazyDFA struct {
+	prog   *program
+	caches sync.Pool // of *dfaCache
+}
+
+// dfaCache holds the states built by the matches that have used it, which
+// are only ever run by one goroutine at a time
+type dfaCache struct {
+	prog   *program
+	states map[string]*dfaState
+	size   int // number of states and transitions built since the last flush
+
+	// scratch space for closure
+	stack []int
+	seen  []int // generation in which each pc was last visited
+	gen   int
+}
+
+// dfaState is a set of NFA pcs waiting to be followed once the next rune is
+// known, together with what the zero-width conditions there need to know about
+// the rune before it
+type dfaState struct {
+	pcs       []int
+	ctx       emptyOp // conditions implied by the rune before alone
+	afterWord bool    // whether the rune before is a word character
+	afterCR   bool    // whether the rune before is \r
+	next      map[rune]dfaTransition
+	eof       int8 // whether the pattern matches at the end of the input: 0 unknown, 1 yes, -1 no
+}
+
+// dfaTransition is the cached result of feeding one rune to a dfaState
+type dfaTransition struct {
+	state   *dfaState
+	matched bool // the pattern matched just before the rune was consumed
+}
+
+func newLazyDFA(prog *program) *lazyDFA {
+	d := &lazyDFA{prog: prog}
+	d.caches.New = func() any {
+		return &dfaCache{
+			prog:   prog,
+			states: make(map[string]*dfaState),
+			seen:   make([]int, len(prog.insts)),
+		}
+	}
+	return d
+}
+
+// match reports whether the program matches anywhere in input. It reports
+// false if the limit stops it first.
+func (d *lazyDFA) match(input []rune, limit *matchLimit) bool {
+	c := d.caches.Get().(*dfaCache)
+	defer d.caches.Put(c)
+	return c.match(input, limit)
+}
+
+func (c *dfaCache) match(input []rune, limit *matchLimit) bool {
+	s := c.state(nil, emptyBeginText|emptyBeginLine, false, false)
+	for _, r := range input {
+		if !limit.poll() {
+			return false
+		}
+		t, ok := s.next[r]
+		if !ok {
+			t = c.step(s, r)
+		}
+		if t.matched {
+			return true
+		}
+		s = t.state
+	}
+
+	if s.eof == 0 {
+		s.eof = -1
+		for _, pc := range c.closure(s.pcs, s.ctx|emptyEndText|emptyEndLine|wordBoundaryOp(s.afterWord, false)|crlfOp(s.afterCR, false)) {
+			if c.prog.insts[pc].op == instMatch {
+				s.eof = 1
+				break
+			}
+		}
+	}
+	return s.eof == 1
+}
+
+// step computes and caches the transition out of s on r
+func (c *dfaCache) step(s *dfaState, r rune) dfaTransition {
+	var t dfaTransition
+	var pcs []int
+	flags := s.ctx | wordBoundaryOp(s.afterWord, isWordChar(r)) | crlfOp(s.afterCR, r == '\n')
+	var ctx emptyOp
+	if r == '\n' {
+		flags |= emptyEndLine
+		ctx = emptyBeginLine
+	}
+	for _, pc := range c.closure(s.pcs, flags) {
+		in := &c.prog.insts[pc]
+		switch in.op {
+		case instMatch:
+			t.matched = true
+		case instRune:
+			if in.elem.Match(r) {
+				pcs = append(pcs, pc+1)
+			}
+		}
+	}
+	slices.Sort(pcs)
+
+	t.state = c.state(pcs, ctx, isWordChar(r), r == '\r')
+	s.next[r] = t
+	c.size++
+	return t
+}
+
+// closure follows pcs, and a fresh attempt starting at pc 0, through every
+// instruction that does not consume input under the conditions in flags. It
+// returns the pcs of the instRune and instMatch instructions reached.
+func (c *dfaCache) closure(pcs []int, flags emptyOp) []int {
+	c.gen++
+	var out []int
+	stack := append(c.stack[:0], 0)
+	for i := len(pcs) - 1; i >= 0; i-- {
+		stack = append(stack, pcs[i])
+	}
+
+	for len(stack) > 0 {
+		pc := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if c.seen[pc] == c.gen {
+			continue
+		}
+		c.seen[pc] = c.gen
+
+		in := &c.prog.insts[pc]
+		switch in.op {
+		case instJmp:
+			stack = append(stack, in.x)
+		case instSplit:
+			stack = append(stack, in.y, in.x)
+		case instSave:
+			stack = append(stack, pc+1)
+		case instEmpty:
+			if emptyOp(in.arg)&^flags == 0 {
+				stack = append(stack, pc+1)
+			}
+		default:
+			out = append(out, pc)
+		}
+	}
+
+	c.stack = stack
+	return out
+}
+
+// state returns the cached state for pcs and its context, creating it if
+// needed. The whole cache is flushed first if it has reached dfaCacheSize;
+// states already in use by a match carry on, but are no longer shared.
+func (c *dfaCache) state(pcs []int, ctx emptyOp, afterWord, afterCR bool) *dfaState {
+	if c.size >= dfaCacheSize {
+		c.states = make(map[string]*dfaState)
+		c.size = 0
+	}
+
+	key := make([]byte, 0, 2+4*len(pcs))
+	key = append(key, byte(ctx))
+	var before byte
+	if afterWord {
+		before |= 1
+	}
+	if afterCR {
+		before |= 2
+	}
+	key = append(key, before)
+	for _, pc := range pcs {
+		key = append(key, byte(pc), byte(pc>>8), byte(pc>>16), byte(pc>>24))
+	}
+
+	if s, ok := c.states[string(key)]; ok {
+		return s
+	}
+	s := &dfaState{pcs: pcs, ctx: ctx, afterWord: afterWord, afterCR: afterCR, next: make(map[rune]dfaTransition)}
+	c.states[string(key)] = s
+	c.size++
+	return s
+}
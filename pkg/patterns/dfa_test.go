@@ -0,0 +1,67 @@
+package patterns
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDFAConcurrentMatch(t *testing.T) {
+	p, err := ParsePattern(`(\w+)@(\w+)\.com\b`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := [][]rune{
+		[]rune(strings.Repeat("some log line ", 20) + "foo@bar.com"),
+		[]rune(strings.Repeat("some log line ", 20) + "foo@bar.comma"),
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				if !p.Match(lines[0]) || p.Match(lines[1]) {
+					t.Error("wrong answer from a shared pattern")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkDFAParallelMatch(b *testing.B) {
+	p, _ := ParsePattern(`(\w+)@(\w+)\.com`)
+	line := []rune(strings.Repeat("some log line without the thing ", 10) + "foo@bar.com")
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			p.Match(line)
+		}
+	})
+}
+
+func TestDFACacheBound(t *testing.T) {
+	p, err := ParsePattern(`[^z]+z`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every distinct rune adds a transition out of the same few states
+	input := make([]rune, 3*dfaCacheSize)
+	for i := range input {
+		input[i] = 0x10000 + rune(i)
+	}
+
+	c := p.dfa.caches.New().(*dfaCache)
+	if c.match(input, nil) {
+		t.Fatal("got a match, want none")
+	}
+	transitions := 0
+	for _, s := range c.states {
+		transitions += len(s.next)
+	}
+	if n := len(c.states) + transitions; n > dfaCacheSize+1 {
+		t.Errorf("got %d states and transitions cached, want at most %d", n, dfaCacheSize+1)
+	}
+}
@@ -0,0 +1,208 @@
+package patterns
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+)
+
+// differentialPatterns are written in the syntax shared with package regexp,
+// which is used as the reference for what they match and capture
+var differentialPatterns = []string{
+	// Literals, classes and anchors
+	`a`, `abc`, `^abc`, `abc$`, `^abc$`, `^`, `$`, `^$`, `.`, `a.c`,
+	`\d+`, `\w+`, `\s`, `\D\W\S`, `[abc]`, `[^abc]+`, `[a-c0-9_]+`,
+	`\bab`, `ab\b`, `\Bb\B`, `(?i)AbC`, `(?s)a.c`, `(?m)^b$`, `\Aab`, `bc\z`,
+
+	// Alternation
+	`a|b`, `ab|a`, `a|ab`, `a|`, `|a`, `cat|dog`, `^a|c$`, `x|y|z`,
+
+	// Quantifiers, greedy and lazy
+	`a*`, `a+`, `a?`, `a*?`, `a+?`, `a??`, `a{2}`, `a{2,}`, `a{1,3}`, `a{1,3}?`,
+	`ba*c`, `x*y*z*`, `a{0}b`, `.*c`, `.*?c`,
+
+	// Top-level groups
+	`(a)`, `(a)(b)`, `(a|ab)(c|bcd)`, `(a*)(a*)`, `(a*?)(a*)`, `(a+?)(a*)`,
+	`(a??)(a*)`, `(ab)?c`, `(a)|(b)`, `(?:a|b)(c)`, `(?P<first>a)(?P<second>b)?`,
+
+	// Nested and repeated groups
+	`((a)b)`, `((a)|b)+`, `(?:(a)|b)+`, `(a|(b))*`, `(?:(a)|(b))+c`, `((a)|(b))*`,
+	`(a(b(c)))`, `((a+)(b+))+`, `(a|ab)*c`, `x(a|b)+y`, `(a|b){2,3}c`, `(ab){2}`,
+	`((ab)|(a))*b`, `(a+)+b`, `(a*)+`, `(a?)*`, `(a*)*`, `(a*?)*`, `(a??)+`,
+	`(a*)*b`, `x(a?){2,}`, `(a?){0,3}`, `(a?){2,3}`, `(a*)+?b`, `(a*){1,}`,
+	`(a??){1,3}`, `(a?)?`, `(a*?)+`, `((a)*)*`, `(?:(a)*)+b`, `(a(b)?)+`,
+}
+
+var differentialInputs = []string{
+	"", "a", "b", "c", "ab", "ba", "abc", "abab", "aabc", "aaab", "abcd", "bcd",
+	"xaa", "x", "xababy", "xy", "ABC", "a\nc", "ab\nb\nc", "cat dog", "b b", "aaaa",
+	"abbc", "ac",
+}
+
+// withBacktracker returns a copy of p that is always matched by the
+// backtracker, so that it can be compared with the engines that p would
+// otherwise use
+func withBacktracker(p *Pattern) *Pattern {
+	prog := *p.prog
+	prog.backtrack = true
+	q := *p
+	q.prog, q.dfa = &prog, nil
+	return &q
+}
+
+// byteIndex turns spans into the byte offsets that package regexp reports
+func byteIndex(spans []Span) []int {
+	var index []int
+	for _, span := range spans {
+		index = append(index, span.ByteStart, span.ByteEnd)
+	}
+	return index
+}
+
+func TestDifferentialFindSubmatch(t *testing.T) {
+	for _, pattern := range differentialPatterns {
+		re := regexp.MustCompile(pattern)
+		p, err := ParsePattern(pattern)
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", pattern, err)
+		}
+		engines := map[string]*Pattern{"default": p, "backtracker": withBacktracker(p)}
+		for name, p := range engines {
+			for _, input := range differentialInputs {
+				want := re.FindStringSubmatchIndex(input)
+				if got := byteIndex(p.FindSubmatchIndex([]rune(input))); !slices.Equal(got, want) {
+					t.Errorf("%s: %q on %q: got %v, want %v", name, pattern, input, got, want)
+				}
+				if got := p.Match([]rune(input)); got != (want != nil) {
+					t.Errorf("%s: %q on %q: Match got %v", name, pattern, input, got)
+				}
+			}
+		}
+	}
+}
+
+func TestDifferentialFindAll(t *testing.T) {
+	for _, pattern := range differentialPatterns {
+		re := regexp.MustCompile(pattern)
+		p, err := ParsePattern(pattern)
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", pattern, err)
+		}
+		engines := map[string]*Pattern{"default": p, "backtracker": withBacktracker(p)}
+		for name, p := range engines {
+			for _, input := range differentialInputs {
+				var want []int
+				for _, match := range re.FindAllStringIndex(input, -1) {
+					want = append(want, match...)
+				}
+				if got := byteIndex(p.FindAllIndex([]rune(input), -1)); !slices.Equal(got, want) {
+					t.Errorf("%s: %q on %q: got %v, want %v", name, pattern, input, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestDifferentialLongest(t *testing.T) {
+	for _, pattern := range differentialPatterns {
+		re := regexp.MustCompile(pattern)
+		re.Longest()
+		p, err := ParsePattern(pattern, ParseOptions{Longest: true})
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", pattern, err)
+		}
+		engines := map[string]*Pattern{"default": p, "backtracker": withBacktracker(p)}
+		for name, p := range engines {
+			for _, input := range differentialInputs {
+				// Only the overall match is compared, as package regexp does
+				// not capture by the POSIX rules
+				want := re.FindStringIndex(input)
+				got, ok := p.FindIndex([]rune(input))
+				if ok != (want != nil) || ok && !slices.Equal([]int{got.ByteStart, got.ByteEnd}, want) {
+					t.Errorf("%s: %q on %q: got %v, want %v", name, pattern, input, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestLongestCaptures(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    []int // capture slots in runes
+	}{
+		// Each group in turn starts earliest and then matches the longest text.
+		// The backtracker, kept for patterns that need it, only makes the
+		// overall match longest.
+		{`(a|ab)(bc|c)`, "abc", []int{0, 3, 0, 2, 2, 3}},
+		{`(a*)(a*)`, "aaa", []int{0, 3, 0, 3, 3, 3}},
+		{`(a|ab)(c|bcd)(d*)`, "abcd", []int{0, 4, 0, 2, 2, 3, 3, 4}},
+		{`(a*)+`, "aa", []int{0, 2, 0, 2}},
+		{`(a|ab)(c|bcd)?`, "abcd", []int{0, 4, 0, 1, 1, 4}},
+		{`x(a|b)?`, "x", []int{0, 1, -1, -1}},
+	}
+	for _, tt := range tests {
+		p, err := ParsePattern(tt.pattern, ParseOptions{Longest: true})
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", tt.pattern, err)
+		}
+		var got []int
+		for _, span := range p.FindSubmatchIndex([]rune(tt.input)) {
+			got = append(got, span.Start, span.End)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q on %q: got %v, want %v", tt.pattern, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestBacktrackerCaptures(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    []int // capture slots in runes, nil for no match
+	}{
+		// The rest of the pattern can make the backtracker take another way
+		// through a group that has already matched
+		{`(?:a|ab)(c)\1`, "abcc", []int{0, 4, 2, 3}},
+		{`(a|ab)(c|bcd)\2`, "abcdbcd", []int{0, 7, 0, 1, 1, 4}},
+		{`(a+)+\1b`, "aaab", []int{0, 4, 1, 2}},
+		{`^(\w+)\s+\1$`, "hello hello", []int{0, 11, 0, 5}},
+		{`^(\w+)\s+\1$`, "hello help", nil},
+
+		// A backreference inside its own group sees the previous iteration
+		{`^(a|b\1)+$`, "aba", []int{0, 3, 1, 3}},
+		{`^(a|b\1)+$`, "abb", nil},
+		{`(a)|\1`, "b", nil},
+		{`(a?)\1b`, "b", []int{0, 1, 0, 0}},
+
+		// Atomic groups and possessive quantifiers never give anything back
+		{`(?>a+)b`, "aaab", []int{0, 4}},
+		{`(?>a+)a`, "aaa", nil},
+		{`a*+a`, "aaaa", nil},
+		{`(?>(a|ab))c`, "abc", nil},
+		{`(a|ab)c`, "abc", []int{0, 3, 0, 2}},
+
+		// Lookarounds test without consuming, and keep the groups of a positive one
+		{`a(?=b)`, "ab", []int{0, 1}},
+		{`a(?!b)`, "abac", []int{2, 3}},
+		{`(?=(\w+))\w`, "abc", []int{0, 1, 0, 3}},
+		{`(?<=a)b`, "ab", []int{1, 2}},
+		{`(?<!a)b`, "abcb", []int{3, 4}},
+		{`(?<=(a|bc))d`, "bcd", []int{2, 3, 0, 2}},
+	}
+	for _, tt := range tests {
+		p, err := ParsePattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", tt.pattern, err)
+		}
+		var got []int
+		for _, span := range p.FindSubmatchIndex([]rune(tt.input)) {
+			got = append(got, span.Start, span.End)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q on %q: got %v, want %v", tt.pattern, tt.input, got, tt.want)
+		}
+	}
+}
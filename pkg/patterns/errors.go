@@ -0,0 +1,44 @@
+package patterns
+
+import "fmt"
+
+// ErrorCode describes the kind of syntax error found in a pattern
+type ErrorCode string
+
+const (
+	ErrMissingParen            ErrorCode = "missing closing )"
+	ErrInvalidGroup            ErrorCode = "invalid or unsupported group syntax"
+	ErrInvalidNamedCapture     ErrorCode = "invalid named capture"
+	ErrInvalidLookbehind       ErrorCode = "lookbehind body has no maximum length"
+	ErrUnexpectedParen         ErrorCode = "unexpected )"
+	ErrMissingBracket          ErrorCode = "missing closing ]"
+	ErrInvalidCharRange        ErrorCode = "invalid character class range"
+	ErrInvalidCharClass        ErrorCode = "invalid character class"
+	ErrBareCharClass           ErrorCode = "character class syntax is [[:space:]], not [:space:]"
+	ErrInvalidCollatingElement ErrorCode = "invalid collating element"
+	ErrInvalidUnicodeClass     ErrorCode = "invalid Unicode class"
+	ErrInvalidEscape           ErrorCode = "invalid escape sequence"
+	ErrTrailingBackslash       ErrorCode = "trailing backslash at end of pattern"
+	ErrInvalidBackReference    ErrorCode = "invalid backreference"
+	ErrMissingRepeatArgument   ErrorCode = "missing argument to repetition operator"
+	ErrInvalidNestedRepeat     ErrorCode = "invalid nested repetition operator"
+	ErrInvalidRepeatOp         ErrorCode = "invalid repetition operator"
+	ErrInvalidRepeatSize       ErrorCode = "invalid repeat count"
+)
+
+// ParseError describes a syntax error in a pattern passed to ParsePattern
+type ParseError struct {
+	Code     ErrorCode
+	Offset   int    // offset in runes of the start of Fragment within the pattern
+	Fragment string // the part of the pattern that is in error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at offset %d: `%s`", e.Code, e.Offset, e.Fragment)
+}
+
+// newParseError reports code for runes[start:end], where offset is the
+// position of runes[0] within the whole pattern
+func newParseError(code ErrorCode, runes []rune, start, end, offset int) *ParseError {
+	return &ParseError{Code: code, Offset: offset + start, Fragment: string(runes[start:end])}
+}
@@ -0,0 +1,111 @@
+package patterns
+
+import (
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// controlEscapes maps the letter of each single-letter escape for a control
+// character to the character it stands for
+var controlEscapes = map[rune]rune{
+	'a': '\a',
+	'e': 0x1b,
+	'f': '\f',
+	'n': '\n',
+	'r': '\r',
+	't': '\t',
+}
+
+// parseCharEscape parses the escape sequence for a single character that
+// starts with the backslash at runes[i], returning the character and the index
+// just past the sequence. ok is false if the escape is not a character escape
+// at all. An ASCII letter or digit with no meaning is reserved for future use
+// and rejected; any other escaped character stands for itself.
+func parseCharEscape(runes []rune, i, offset int) (r rune, next int, ok bool, err error) {
+	c := runes[i+1]
+	if ctrl, ok := controlEscapes[c]; ok {
+		return ctrl, i + 2, true, nil
+	}
+
+	switch c {
+	case 'c':
+		// \cX is the control character for X, so \cJ is a newline
+		if i+2 >= len(runes) || runes[i+2] < ' ' || runes[i+2] > '~' {
+			return 0, 0, false, newParseError(ErrInvalidEscape, runes, i, min(i+3, len(runes)), offset)
+		}
+		x := runes[i+2]
+		if 'a' <= x && x <= 'z' {
+			x -= 'a' - 'A'
+		}
+		return x ^ 0x40, i + 3, true, nil
+
+	case 'x':
+		// \xhh with one or two hex digits, or \x{h...}
+		if i+2 < len(runes) && runes[i+2] == '{' {
+			return parseBracedCodePoint(runes, i, 16, offset)
+		}
+		return parseCodePoint(runes, i, i+2, min(i+4, len(runes)), 16, isHexDigit, offset)
+
+	case 'u':
+		// \uhhhh with exactly four hex digits
+		r, next, ok, err := parseCodePoint(runes, i, i+2, min(i+6, len(runes)), 16, isHexDigit, offset)
+		if err == nil && next != i+6 {
+			return 0, 0, false, newParseError(ErrInvalidEscape, runes, i, next, offset)
+		}
+		return r, next, ok, err
+
+	case 'o':
+		// \o{ooo}
+		if i+2 >= len(runes) || runes[i+2] != '{' {
+			return 0, 0, false, newParseError(ErrInvalidEscape, runes, i, i+2, offset)
+		}
+		return parseBracedCodePoint(runes, i, 8, offset)
+
+	case '0':
+		// \0 followed by up to two more octal digits
+		return parseCodePoint(runes, i, i+1, min(i+4, len(runes)), 8, isOctalDigit, offset)
+	}
+
+	if c < utf8.RuneSelf && isWordChar(c) {
+		return 0, 0, false, nil
+	}
+	return c, i + 2, true, nil
+}
+
+// parseCodePoint reads the code point written in base as the longest run of
+// digits in runes[start:end], of which there must be at least one. esc is the
+// index of the backslash that starts the escape.
+func parseCodePoint(runes []rune, esc, start, end, base int, isDigit func(rune) bool, offset int) (rune, int, bool, error) {
+	next := start
+	for next < end && isDigit(runes[next]) {
+		next++
+	}
+	if next == start {
+		return 0, 0, false, newParseError(ErrInvalidEscape, runes, esc, min(next+1, len(runes)), offset)
+	}
+	n, _ := strconv.ParseUint(string(runes[start:next]), base, 32)
+	return rune(n), next, true, nil
+}
+
+// parseBracedCodePoint reads a code point written in base between the braces
+// of an escape such as \x{1F600}, where runes[esc] is the backslash
+func parseBracedCodePoint(runes []rune, esc, base int, offset int) (rune, int, bool, error) {
+	end := esc + 3
+	for end < len(runes) && runes[end] != '}' {
+		end++
+	}
+	if end == len(runes) {
+		return 0, 0, false, newParseError(ErrInvalidEscape, runes, esc, end, offset)
+	}
+	n, err := strconv.ParseUint(string(runes[esc+3:end]), base, 32)
+	if err != nil || n > unicode.MaxRune || 0xd800 <= n && n <= 0xdfff {
+		// Not a number, or not a valid code point
+		return 0, 0, false, newParseError(ErrInvalidEscape, runes, esc, end+1, offset)
+	}
+	return rune(n), end + 1, true, nil
+}
+
+func isOctalDigit(r rune) bool {
+	return '0' <= r && r <= '7'
+}
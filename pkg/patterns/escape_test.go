@@ -0,0 +1,96 @@
+package patterns
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEscapes(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    bool
+	}{
+		{`^\t$`, "\t", true},
+		{`^\n$`, "\n", true},
+		{`^\r\f\a$`, "\r\f\a", true},
+		{`^\e$`, "\x1b", true},
+		{`^\cJ$`, "\n", true},
+		{`^\cj$`, "\n", true},
+		{`^\c?$`, "\x7f", true},
+		{`^\x41$`, "A", true},
+		{`^\x411$`, "A1", true},
+		{`^\x7$`, "\x07", true},
+		{`^\x{1F600}$`, "😀", true},
+		{`^\x{e9}+$`, "éé", true},
+		{`^\u00e9$`, "é", true},
+		{`^\é$`, "é", true},
+		{`^\0$`, "\x00", true},
+		{`^\012$`, "\n", true},
+		{`^\0123$`, "\n3", true},
+		{`^\o{17}$`, "\x0f", true},
+		{`^\o{1750}$`, "Ϩ", true},
+		{`^[\t\x41é]+$`, "\tAé", true},
+		{`^\.\*\\$`, `.*\`, true},
+
+		// \Q...\E quotes everything up to \E, or to the end of the pattern
+		{`^\Q.*+(\E$`, ".*+(", true},
+		{`^\Q.*+(\E$`, "a", false},
+		{`^a\Q|b`, "a|b", true},
+		{`^a\Q|b`, "b", false},
+		{`^\Q\E$`, "", true},
+		{`^a\Eb$`, "ab", true},
+		// A quantifier after \E applies to the last quoted character only
+		{`^\Qab\E+$`, "abbb", true},
+		{`^\Qab\E+$`, "abab", false},
+		{`^\Qa.\E{2}$`, "a..", true},
+	}
+	for _, tt := range tests {
+		p, err := ParsePattern(tt.pattern)
+		if err != nil {
+			t.Errorf("ParsePattern(%q): %v", tt.pattern, err)
+			continue
+		}
+		if got := p.Match([]rune(tt.input)); got != tt.want {
+			t.Errorf("%q on %q: got %v, want %v", tt.pattern, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeErrors(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		offset   int
+		fragment string
+	}{
+		// Letters and digits with no meaning are reserved
+		{`\y`, 0, `\y`},
+		{`a\i`, 1, `\i`},
+		{`\L`, 0, `\L`},
+		{`\_`, 0, `\_`},
+		{`[\y]`, 1, `\y`},
+		{`[\R]`, 1, `\R`},
+
+		{`\c`, 0, `\c`},
+		{`\xZ`, 0, `\xZ`},
+		{`\x{}`, 0, `\x{}`},
+		{`\x{12`, 0, `\x{12`},
+		{`\x{110000}`, 0, `\x{110000}`},
+		{`\x{D800}`, 0, `\x{D800}`},
+		{`\u12`, 0, `\u12`},
+		{`\o17`, 0, `\o`},
+		{`\o{8}`, 0, `\o{8}`},
+	}
+	for _, tt := range tests {
+		_, err := ParsePattern(tt.pattern)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("ParsePattern(%q): got %v, want a *ParseError", tt.pattern, err)
+			continue
+		}
+		want := ParseError{Code: ErrInvalidEscape, Offset: tt.offset, Fragment: tt.fragment}
+		if *parseErr != want {
+			t.Errorf("ParsePattern(%q): got %#v, want %#v", tt.pattern, *parseErr, want)
+		}
+	}
+}
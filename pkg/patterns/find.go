@@ -0,0 +1,213 @@
+package patterns
+
+import (
+	"context"
+	"iter"
+	"slices"
+	"unicode/utf8"
+)
+
+// Span locates a match, or the text captured by a group, within the input. A
+// group that took no part in the match has every offset set to -1.
+//
+// A group that matches more than once, because it is repeated, captures the
+// text of the last iteration in which it took part, as in Perl and package
+// regexp. A group nested inside it keeps what it captured in an earlier
+// iteration if it took no part in the last one, so (?:(a)|b)+ matched against
+// "ab" leaves group 1 holding "a". FindSubmatchCaptures returns every
+// iteration instead.
+type Span struct {
+	Start, End         int // offsets in runes
+	ByteStart, ByteEnd int // offsets in bytes of the input encoded as UTF-8
+}
+
+// Find returns the text of the leftmost match of p in input, or nil if there
+// is none. An empty match returns an empty, non-nil slice.
+func (p *Pattern) Find(input []rune) []rune {
+	caps := p.find(input, 0)
+	if caps == nil {
+		return nil
+	}
+	return input[caps[0]:caps[1]:caps[1]]
+}
+
+// FindIndex returns the location of the leftmost match of p in input, and
+// false if there is none
+func (p *Pattern) FindIndex(input []rune) (Span, bool) {
+	caps := p.find(input, 0)
+	if caps == nil {
+		return Span{}, false
+	}
+	return newSpans(caps[:2], byteOffsets(input))[0], true
+}
+
+// FindAll returns the text of successive non-overlapping matches of p in
+// input, as for FindAllIndex
+func (p *Pattern) FindAll(input []rune, n int) [][]rune {
+	var matches [][]rune
+	for _, span := range p.FindAllIndex(input, n) {
+		matches = append(matches, input[span.Start:span.End:span.End])
+	}
+	return matches
+}
+
+// FindAllIndex returns the locations of successive non-overlapping matches of
+// p in input, at most n of them unless n is negative. As in package regexp,
+// an empty match straight after the previous match is skipped, and the search
+// moves on by one rune after an empty match. It returns nil if there is no
+// match.
+func (p *Pattern) FindAllIndex(input []rune, n int) []Span {
+	spans, _ := p.findAllIndex(nil, input, n)
+	return spans
+}
+
+// FindAllIndexContext is like FindAllIndex, but gives up once ctx is done,
+// returning ctx.Err(), or once one of the matches has taken more steps than
+// the MatchLimit p was parsed with, returning ErrMatchLimit
+func (p *Pattern) FindAllIndexContext(ctx context.Context, input []rune, n int) ([]Span, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return p.findAllIndex(ctx, input, n)
+}
+
+func (p *Pattern) findAllIndex(ctx context.Context, input []rune, n int) ([]Span, error) {
+	var spans []Span
+	var offsets []int
+	var err error
+	for caps := range p.allMatches(ctx, input, n, &err) {
+		if offsets == nil {
+			offsets = byteOffsets(input)
+		}
+		spans = append(spans, newSpans(caps[:2], offsets)[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return spans, nil
+}
+
+// allMatches yields the capture slots of successive non-overlapping matches of
+// p in input, at most n of them unless n is negative, skipping empty matches
+// as described for FindAllIndex. Each match is limited as for MatchContext
+// under ctx. If ctx is nil, a match that goes over the limit is given up as no
+// match and err may be nil; otherwise the search stops there and *err is set
+// to why.
+func (p *Pattern) allMatches(ctx context.Context, input []rune, n int, err *error) iter.Seq[[]int] {
+	return func(yield func([]int) bool) {
+		for pos, prevEnd, count := 0, -1, 0; (n < 0 || count < n) && pos <= len(input); {
+			limit := p.newMatchLimit(ctx)
+			caps := p.search(input, pos, limit, false).caps
+			if limit.stopped() && ctx != nil {
+				*err = limit.err
+				return
+			}
+			if caps == nil {
+				return
+			}
+
+			accept := true
+			if caps[1] == pos {
+				// An empty match: step past it so the search moves on
+				if caps[0] == prevEnd {
+					accept = false
+				}
+				pos++
+			} else {
+				pos = caps[1]
+			}
+			prevEnd = caps[1]
+
+			if accept {
+				if !yield(caps) {
+					return
+				}
+				count++
+			}
+		}
+	}
+}
+
+// FindSubmatch returns the text of the leftmost match of p in input followed
+// by the text captured by each group, with nil for a group that did not take
+// part in the match. It returns nil if there is no match.
+func (p *Pattern) FindSubmatch(input []rune) [][]rune {
+	caps := p.find(input, 0)
+	if caps == nil {
+		return nil
+	}
+	submatches := make([][]rune, len(caps)/2)
+	for i := range submatches {
+		if start, end := caps[2*i], caps[2*i+1]; start >= 0 {
+			submatches[i] = input[start:end:end]
+		}
+	}
+	return submatches
+}
+
+// FindSubmatchIndex returns the location of the leftmost match of p in input
+// followed by the location of the text captured by each group. It returns nil
+// if there is no match.
+func (p *Pattern) FindSubmatchIndex(input []rune) []Span {
+	caps := p.find(input, 0)
+	if caps == nil {
+		return nil
+	}
+	return newSpans(caps, byteOffsets(input))
+}
+
+// FindSubmatchCaptures is like FindSubmatchIndex, but returns for each group
+// the location of the text it captured in every iteration of the leftmost
+// match, in the order they were captured, like the Captures collection of a
+// .NET Group. It returns nil if there is no match; the element for a group
+// that took no part in the match is empty, and the element for the whole
+// match holds just its location.
+func (p *Pattern) FindSubmatchCaptures(input []rune) [][]Span {
+	matched := p.search(input, 0, p.newMatchLimit(nil), true)
+	caps, hist := matched.caps, matched.hist
+	if caps == nil {
+		return nil
+	}
+
+	offsets := byteOffsets(input)
+	captures := make([][]Span, len(caps)/2)
+	captures[0] = newSpans(caps[:2], offsets)
+	for ; hist != nil; hist = hist.prev {
+		span := newSpans([]int{hist.start, hist.end}, offsets)[0]
+		captures[hist.group] = append(captures[hist.group], span)
+	}
+	for _, spans := range captures[1:] {
+		// The log runs from the most recent capture back
+		slices.Reverse(spans)
+	}
+	return captures
+}
+
+// byteOffsets returns the byte offset of every rune in input, when encoded as
+// UTF-8, followed by the length of the whole encoding
+func byteOffsets(input []rune) []int {
+	offsets := make([]int, len(input)+1)
+	for i, r := range input {
+		n := utf8.RuneLen(r)
+		if n < 0 {
+			// Invalid runes are encoded as U+FFFD
+			n = utf8.RuneLen(utf8.RuneError)
+		}
+		offsets[i+1] = offsets[i] + n
+	}
+	return offsets
+}
+
+// newSpans converts pairs of capture slots to Spans
+func newSpans(caps []int, offsets []int) []Span {
+	spans := make([]Span, len(caps)/2)
+	for i := range spans {
+		start, end := caps[2*i], caps[2*i+1]
+		if start < 0 {
+			spans[i] = Span{Start: -1, End: -1, ByteStart: -1, ByteEnd: -1}
+			continue
+		}
+		spans[i] = Span{Start: start, End: end, ByteStart: offsets[start], ByteEnd: offsets[end]}
+	}
+	return spans
+}
@@ -0,0 +1,125 @@
+package patterns
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFindAllIndexEmptyMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		n       int
+		want    [][2]int // rune offsets of each match
+	}{
+		// An empty match at every position, including the end
+		{`x*`, "abc", -1, [][2]int{{0, 0}, {1, 1}, {2, 2}, {3, 3}}},
+		// No empty match straight after a non-empty one
+		{`a*`, "baaac", -1, [][2]int{{0, 0}, {1, 4}, {5, 5}}},
+		{`a*`, "aab", -1, [][2]int{{0, 2}, {3, 3}}},
+		{`a|`, "aab", -1, [][2]int{{0, 1}, {1, 2}, {3, 3}}},
+		{`\b`, "ab cd", -1, [][2]int{{0, 0}, {2, 2}, {3, 3}, {5, 5}}},
+		{`x*`, "", -1, [][2]int{{0, 0}}},
+		{`x*`, "abc", 2, [][2]int{{0, 0}, {1, 1}}},
+		{`a`, "aaa", 0, nil},
+		{`b`, "aaa", -1, nil},
+	}
+	for _, tt := range tests {
+		p, err := ParsePattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", tt.pattern, err)
+		}
+		var got [][2]int
+		for _, span := range p.FindAllIndex([]rune(tt.input), tt.n) {
+			got = append(got, [2]int{span.Start, span.End})
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q on %q, n=%d: got %v, want %v", tt.pattern, tt.input, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestFindOffsets(t *testing.T) {
+	p, err := ParsePattern(`(é+)(x)?`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := []rune("aéé!")
+	want := []Span{
+		{Start: 1, End: 3, ByteStart: 1, ByteEnd: 5},
+		{Start: 1, End: 3, ByteStart: 1, ByteEnd: 5},
+		{Start: -1, End: -1, ByteStart: -1, ByteEnd: -1},
+	}
+	if got := p.FindSubmatchIndex(input); !slices.Equal(got, want) {
+		t.Errorf("FindSubmatchIndex: got %v, want %v", got, want)
+	}
+	if got := p.FindSubmatch(input); len(got) != 3 || string(got[1]) != "éé" || got[2] != nil {
+		t.Errorf("FindSubmatch: got %q, want the match, group 1 and a nil group 2", got)
+	}
+
+	empty, _ := ParsePattern(`x*`)
+	if got := empty.Find([]rune("abc")); got == nil || len(got) != 0 {
+		t.Errorf("Find: got %q, want an empty, non-nil match", got)
+	}
+	if _, ok := p.FindIndex([]rune("abc")); ok {
+		t.Error("FindIndex: got a match, want none")
+	}
+}
+
+func TestFindSubmatchCaptures(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    [][][2]int // rune offsets of every capture by each group
+	}{
+		{`(?:(\w+)=(\w*);?)+`, "a=1;bb=;c=33", [][][2]int{
+			{{0, 1}, {4, 6}, {8, 9}},
+			{{2, 3}, {7, 7}, {10, 12}},
+		}},
+		{`(\w+=\w+;?)+`, "a=1;b=2", [][][2]int{{{0, 4}, {4, 7}}}},
+		{`(a|b)+`, "abb", [][][2]int{{{0, 1}, {1, 2}, {2, 3}}}},
+		{`((a)|b)+`, "ab", [][][2]int{{{0, 1}, {1, 2}}, {{0, 1}}}},
+		{`(x)*y`, "y", [][][2]int{nil}},
+		// An empty iteration is only ever the last
+		{`(a?)+`, "aa", [][][2]int{{{0, 1}, {1, 2}}}},
+		{`(a|)+`, "aa", [][][2]int{{{0, 1}, {1, 2}}}},
+		{`(x*)*`, "xx", [][][2]int{{{0, 2}}}},
+		{`((a?))+`, "aa", [][][2]int{{{0, 1}, {1, 2}}, {{0, 1}, {1, 2}}}},
+	}
+	for _, longest := range []bool{false, true} {
+		for _, tt := range tests {
+			p, err := ParsePattern(tt.pattern)
+			if err != nil {
+				t.Fatalf("ParsePattern(%q): %v", tt.pattern, err)
+			}
+			if longest {
+				p.Longest()
+			}
+			input := []rune(tt.input)
+			captures := p.FindSubmatchCaptures(input)
+			if len(captures) != len(tt.want)+1 {
+				t.Fatalf("%q on %q, longest=%v: got %v, want %d groups", tt.pattern, tt.input, longest, captures, len(tt.want))
+			}
+			for i, spans := range captures[1:] {
+				var got [][2]int
+				for _, span := range spans {
+					got = append(got, [2]int{span.Start, span.End})
+				}
+				if !slices.Equal(got, tt.want[i]) {
+					t.Errorf("%q on %q, longest=%v: group %d got %v, want %v", tt.pattern, tt.input, longest, i+1, got, tt.want[i])
+				}
+			}
+			// The last capture by each group is the one FindSubmatchIndex reports
+			for i, span := range p.FindSubmatchIndex(input)[1:] {
+				if spans := captures[i+1]; len(spans) > 0 && spans[len(spans)-1] != span {
+					t.Errorf("%q on %q, longest=%v: group %d last capture %v, FindSubmatchIndex %v", tt.pattern, tt.input, longest, i+1, spans[len(spans)-1], span)
+				}
+			}
+		}
+	}
+
+	p, _ := ParsePattern(`(a)+`)
+	if got := p.FindSubmatchCaptures([]rune("bbb")); got != nil {
+		t.Errorf("got %v, want nil for no match", got)
+	}
+}
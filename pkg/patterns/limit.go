@@ -0,0 +1,75 @@
+package patterns
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrMatchLimit is returned by MatchContext when a match has taken more steps
+// than the MatchLimit the pattern was parsed with allows
+var ErrMatchLimit = errors.New("match step limit exceeded")
+
+// pollInterval is the number of steps a match takes between checks of whether
+// its context is done
+const pollInterval = 1024
+
+// matchLimit stops a match once it has taken too many steps or its context is
+// done. A nil *matchLimit never stops a match.
+type matchLimit struct {
+	ctx   context.Context // nil if there is no context
+	steps int             // steps the backtracker has left, or negative for no limit
+	polls int             // calls to poll since ctx was last checked
+	err   error           // why the match was stopped, once it has been
+}
+
+// newMatchLimit returns the limit on a single match with p under ctx, which
+// may be nil
+func (p *Pattern) newMatchLimit(ctx context.Context) *matchLimit {
+	if ctx == nil && p.matchLimit <= 0 {
+		return nil
+	}
+	l := &matchLimit{ctx: ctx, steps: p.matchLimit}
+	if l.steps <= 0 {
+		l.steps = -1
+	}
+	return l
+}
+
+// step counts a step taken by the backtracker and reports whether the match
+// may go on
+func (l *matchLimit) step() bool {
+	if l == nil {
+		return true
+	}
+	if l.steps == 0 {
+		l.err = ErrMatchLimit
+		return false
+	}
+	if l.steps > 0 {
+		l.steps--
+	}
+	return l.poll()
+}
+
+// poll reports whether the match may go on. The engines that run in linear
+// time call it once for each rune instead of counting steps, so that they
+// only stop when the context is done.
+func (l *matchLimit) poll() bool {
+	if l == nil {
+		return true
+	}
+	if l.err != nil {
+		return false
+	}
+	if l.polls++; l.polls < pollInterval || l.ctx == nil {
+		return true
+	}
+	l.polls = 0
+	l.err = l.ctx.Err()
+	return l.err == nil
+}
+
+// stopped reports whether the match has been stopped
+func (l *matchLimit) stopped() bool {
+	return l != nil && l.err != nil
+}
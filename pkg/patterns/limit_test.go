@@ -0,0 +1,32 @@
+package patterns
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestContextMatchLimit(t *testing.T) {
+	// The first match is cheap; the search for the second backtracks over
+	// every way of splitting the run of a's
+	p, err := ParsePattern(`x|(a*)*\1b`, ParseOptions{MatchLimit: 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := []rune("x" + strings.Repeat("a", 28))
+	ctx := context.Background()
+
+	if matched, err := p.MatchContext(ctx, input); !matched || err != nil {
+		t.Errorf("MatchContext: got %v, %v, want true, nil", matched, err)
+	}
+	if spans, err := p.FindAllIndexContext(ctx, input, -1); spans != nil || !errors.Is(err, ErrMatchLimit) {
+		t.Errorf("FindAllIndexContext: got %v, %v, want nil, ErrMatchLimit", spans, err)
+	}
+	if out, err := p.ReplaceAllContext(ctx, input, []rune("y")); out != nil || !errors.Is(err, ErrMatchLimit) {
+		t.Errorf("ReplaceAllContext: got %q, %v, want nil, ErrMatchLimit", string(out), err)
+	}
+	if got := p.FindAllIndex(input, -1); len(got) != 1 {
+		t.Errorf("FindAllIndex: got %v, want the first match only", got)
+	}
+}
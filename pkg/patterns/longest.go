@@ -0,0 +1,194 @@
+package patterns
+
+// run is like pikeVM.run, but returns the leftmost-longest match as POSIX
+// specifies for egrep rather than the leftmost-first one. Among the ways to
+// make that match, it prefers the one in which each group, from left to
+// right, starts earliest and then matches the longest text.
+//
+// It runs the same lockstep simulation as the Pike VM, but where threads meet
+// at the same pc they are ranked by their captures with posixBetter rather
+// than by the order in which they arrived, and a match does not stop the
+// threads that could still make a longer one.
+func (m *posixVM) run(start int) thread {
+	prog, input := m.prog, m.input
+	var matched thread
+	clist := m.closure([]thread{{pc: 0, caps: newCaptures(prog.numCap)}}, start)
+	for pos := start; ; pos++ {
+		if len(clist) == 0 && matched.caps != nil {
+			break
+		}
+		if !m.limit.poll() {
+			return thread{}
+		}
+
+		var seeds []thread
+		for _, t := range clist {
+			if matched.caps != nil && t.caps[0] > matched.caps[0] {
+				// Started after the match found already, so can only be worse
+				continue
+			}
+			in := &prog.insts[t.pc]
+			switch in.op {
+			case instMatch:
+				if matched.caps == nil || t.caps[0] <= matched.caps[0] {
+					// Leftmost, and longer than any earlier match from the same start
+					matched = t
+				}
+			case instRune:
+				if pos < len(input) && in.elem.Match(input[pos]) {
+					seeds = append(seeds, thread{pc: t.pc + 1, caps: t.caps, hist: t.hist})
+				}
+			}
+		}
+
+		if pos >= len(input) {
+			break
+		}
+		if matched.caps == nil {
+			seeds = append(seeds, thread{pc: 0, caps: newCaptures(prog.numCap)})
+		}
+		clist = m.closure(seeds, pos+1)
+	}
+
+	return matched
+}
+
+// posixVM holds the scratch space for a leftmost-longest search
+type posixVM struct {
+	prog    *program
+	input   []rune
+	best    []thread // thread with the best captures found for each pc in the current closure
+	seen    []int    // generation in which best was last set for each pc
+	gen     int
+	stack   []int
+	history bool // whether threads keep a log of every capture
+	limit   *matchLimit
+}
+
+func newPosixVM(prog *program, input []rune) *posixVM {
+	return &posixVM{
+		prog:  prog,
+		input: input,
+		best:  make([]thread, len(prog.insts)),
+		seen:  make([]int, len(prog.insts)),
+	}
+}
+
+// closure follows seeds through every instruction that does not consume input
+// at pos, keeping for each pc only the best captures that reach it, and
+// returns the threads waiting on a rune or a match. Because a better path may
+// reach a pc after a worse one has already been followed from it, a pc is
+// revisited whenever its captures improve.
+func (m *posixVM) closure(seeds []thread, pos int) []thread {
+	m.gen++
+	var leaves []int
+	relax := func(pc int, t thread) {
+		if m.seen[pc] == m.gen && !posixBetter(t.caps, m.best[pc].caps) {
+			return
+		}
+		if m.seen[pc] != m.gen {
+			switch m.prog.insts[pc].op {
+			case instRune, instMatch:
+				leaves = append(leaves, pc)
+			}
+		}
+		m.seen[pc] = m.gen
+		t.pc = pc
+		m.best[pc] = t
+		m.stack = append(m.stack, pc)
+	}
+	for _, t := range seeds {
+		relax(t.pc, t)
+	}
+
+	var flags emptyOp
+	if len(m.stack) > 0 {
+		flags = emptyOpAt(m.input, pos)
+	}
+	for len(m.stack) > 0 {
+		pc := m.stack[len(m.stack)-1]
+		m.stack = m.stack[:len(m.stack)-1]
+		t := m.best[pc]
+
+		in := &m.prog.insts[pc]
+		switch in.op {
+		case instJmp:
+			relax(in.x, t)
+		case instSplit:
+			relax(in.x, t)
+			relax(in.y, t)
+		case instSave:
+			saved := make([]int, len(t.caps))
+			copy(saved, t.caps)
+			saved[in.arg] = pos
+			hist := t.hist
+			if m.history {
+				hist = record(dropEmptyIteration(hist, saved, in.arg), saved, in.arg)
+			}
+			relax(pc+1, thread{caps: saved, hist: hist})
+		case instEmpty:
+			if emptyOp(in.arg)&^flags == 0 {
+				relax(pc+1, t)
+			}
+		}
+	}
+
+	list := make([]thread, len(leaves))
+	for i, pc := range leaves {
+		list[i] = m.best[pc]
+	}
+	return list
+}
+
+// dropEmptyIteration returns hist without the capture of an empty iteration
+// of a group that the capture completed by the end slot arg of caps follows at
+// the same position, along with the empty captures of the groups nested in it.
+// POSIX lets only the last iteration of a repeat match empty, but ranking by
+// captures alone cannot tell that path from the one that skipped it. The
+// captures of nested groups made since, in the new iteration, are kept.
+func dropEmptyIteration(hist *captureLog, caps []int, arg int) *captureLog {
+	if arg < 2 || arg%2 == 0 {
+		return hist
+	}
+	group, pos := arg/2, caps[arg-1]
+
+	var since []*captureLog
+	e := hist
+	for e != nil && e.group > group && e.start >= pos {
+		since = append(since, e)
+		e = e.prev
+	}
+	if e == nil || e.group != group || e.start != pos || e.end != pos {
+		return hist
+	}
+	e = e.prev
+	for e != nil && e.group > group && e.start == pos && e.end == pos {
+		e = e.prev
+	}
+
+	for i := len(since) - 1; i >= 0; i-- {
+		kept := *since[i]
+		kept.prev = e
+		e = &kept
+	}
+	return e
+}
+
+// posixBetter reports whether the captures a rank before b under the POSIX
+// rules: the match that starts first, then for each group in turn the one
+// that starts first and then ends last. A group that took part in the match
+// ranks before one that did not.
+func posixBetter(a, b []int) bool {
+	if a[0] != b[0] {
+		return a[0] < b[0]
+	}
+	for i := 2; i < len(a); i += 2 {
+		if a[i] != b[i] {
+			return b[i] < 0 || a[i] >= 0 && a[i] < b[i]
+		}
+		if a[i+1] != b[i+1] {
+			return a[i+1] > b[i+1]
+		}
+	}
+	return false
+}
@@ -1,17 +1,34 @@
 package patterns
 
 import (
-	"fmt"
+	"context"
 	"slices"
 	"unicode"
 )
 
 // Pattern represents a sequence of pattern elements to match against
 type Pattern struct {
-	elements    []PatternElement
-	startAnchor bool // true if pattern starts with ^
-	endAnchor   bool // true if pattern ends with $
-	groupCount  int  // number of capturing groups in the pattern
+	elements   []PatternElement
+	groupCount int      // number of capturing groups in the pattern
+	names      []string // name of each capturing group, set on the top-level pattern only
+	longest    bool     // leftmost-longest rather than leftmost-first matching
+	matchLimit int      // steps the backtracker may take in one match, or 0 for no limit
+	// source locates the text of each element within the string given to
+	// ParsePattern, for Analyze to report on
+	source []sourceRange
+
+	// prog is the compiled form of the pattern, run by the linear-time Pike VM
+	// or, when it needs backtracking, by the backtracker. It is only set on
+	// the top-level pattern.
+	prog *program
+	// dfa answers Match for compiled patterns without tracking captures
+	dfa *lazyDFA
+}
+
+// sourceRange is the range of rune offsets from start up to end of some text
+// within a pattern
+type sourceRange struct {
+	start, end int
 }
 
 // PatternElement represents a single element in a pattern that can match runes
@@ -19,45 +36,169 @@ type PatternElement interface {
 	Match(r rune) bool
 }
 
-// LiteralMatcher matches a specific rune
+// assertion is implemented by elements that match a position in the input
+// rather than a rune, and so consume no input. The position matches when all
+// the conditions in emptyOp hold there.
+type assertion interface {
+	PatternElement
+	emptyOp() emptyOp
+}
+
+// LiteralMatcher matches a specific rune, or with caseless set any rune that
+// is the same under Unicode simple case folding
 type LiteralMatcher struct {
-	char rune
+	char     rune
+	caseless bool
 }
 
 func (m LiteralMatcher) Match(r rune) bool {
-	return m.char == r
+	return m.char == r || m.caseless && foldEqual(m.char, r)
 }
 
-// DigitMatcher matches any digit character
-type DigitMatcher struct{}
+// foldEqual reports whether a and b are the same rune under Unicode simple
+// case folding, as in 'k', 'K' and the Kelvin sign
+func foldEqual(a, b rune) bool {
+	for f := unicode.SimpleFold(a); f != a; f = unicode.SimpleFold(f) {
+		if f == b {
+			return true
+		}
+	}
+	return a == b
+}
+
+// DigitMatcher matches any digit character (\d), or any other character when negated (\D)
+type DigitMatcher struct {
+	negated bool
+}
 
 func (m DigitMatcher) Match(r rune) bool {
-	return unicode.IsDigit(r)
+	return unicode.IsDigit(r) != m.negated
 }
 
 // AlphanumericMatcher matches any word character (letter, digit, or underscore)
-type AlphanumericMatcher struct{}
+// with \w, or any other character when negated with \W
+type AlphanumericMatcher struct {
+	negated bool
+}
 
 func (m AlphanumericMatcher) Match(r rune) bool {
-	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+	return (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_') != m.negated
 }
 
-// CharacterSetMatcher matches any character in a set of runes
-type CharacterSetMatcher struct {
-	chars   []rune
+// WhitespaceMatcher matches any Unicode whitespace character (\s), or any
+// other character when negated (\S)
+type WhitespaceMatcher struct {
 	negated bool
 }
 
-func (m CharacterSetMatcher) Match(r rune) bool {
-	if slices.Contains(m.chars, r) {
-		return !m.negated
+func (m WhitespaceMatcher) Match(r rune) bool {
+	return unicode.IsSpace(r) != m.negated
+}
+
+// HorizontalSpaceMatcher matches a space or tab, or one of their Unicode
+// equivalents (\h), or any other character when negated (\H)
+type HorizontalSpaceMatcher struct {
+	negated bool
+}
+
+func (m HorizontalSpaceMatcher) Match(r rune) bool {
+	return (r == '\t' || unicode.Is(unicode.Zs, r) || r == '\u180e') != m.negated
+}
+
+// VerticalSpaceMatcher matches a character that ends a line (\v), from line
+// feed to carriage return plus NEL and the Unicode line and paragraph
+// separators, or any other character when negated (\V)
+type VerticalSpaceMatcher struct {
+	negated bool
+}
+
+func (m VerticalSpaceMatcher) Match(r rune) bool {
+	return ('\n' <= r && r <= '\r' || r == '\u0085' || r == '\u2028' || r == '\u2029') != m.negated
+}
+
+// LinebreakMatcher matches any line break (\R): either the two-rune sequence
+// \r\n, or a single vertical space character other than the \r of a CRLF
+// pair, which is never split into two line breaks
+type LinebreakMatcher struct{}
+
+func (m LinebreakMatcher) Match(r rune) bool {
+	return VerticalSpaceMatcher{}.Match(r)
+}
+
+// WordBoundaryMatcher matches the position between a word character, as
+// defined by AlphanumericMatcher, and a non-word character or the edge of the
+// input (\b), or any other position when negated (\B)
+type WordBoundaryMatcher struct {
+	negated bool
+}
+
+func (m WordBoundaryMatcher) Match(r rune) bool {
+	// Not used directly; assertions match positions rather than runes
+	return false
+}
+
+func (m WordBoundaryMatcher) emptyOp() emptyOp {
+	if m.negated {
+		return emptyNoWordBoundary
+	}
+	return emptyWordBoundary
+}
+
+// StartAnchorMatcher matches the start of the input (^ or \A), or in multiline
+// mode the start of any line
+type StartAnchorMatcher struct {
+	multiline bool
+}
+
+func (m StartAnchorMatcher) Match(r rune) bool {
+	// Not used directly; assertions match positions rather than runes
+	return false
+}
+
+func (m StartAnchorMatcher) emptyOp() emptyOp {
+	if m.multiline {
+		return emptyBeginLine
+	}
+	return emptyBeginText
+}
+
+// EndAnchorMatcher matches the end of the input, or in multiline mode the end
+// of any line. With finalNewline set, as for \Z, it also matches before a
+// newline that ends the input.
+type EndAnchorMatcher struct {
+	multiline    bool
+	finalNewline bool
+}
+
+func (m EndAnchorMatcher) Match(r rune) bool {
+	// Not used directly; assertions match positions rather than runes
+	return false
+}
+
+func (m EndAnchorMatcher) emptyOp() emptyOp {
+	switch {
+	case m.multiline:
+		return emptyEndLine
+	case m.finalNewline:
+		return emptyEndTextNewline
 	}
-	return m.negated
+	return emptyEndText
 }
 
+// quantifierMode selects how a quantifier trades off repeating its element
+// again against moving on to the rest of the pattern
+type quantifierMode uint8
+
+const (
+	greedy     quantifierMode = iota // repeat as often as possible, giving repetitions back when the rest fails
+	lazy                             // repeat as rarely as possible, taking more when the rest fails
+	possessive                       // repeat as often as possible and never give any back
+)
+
 // OneOrMoreMatcher matches the underlying pattern one or more times
 type OneOrMoreMatcher struct {
 	matcher PatternElement
+	mode    quantifierMode
 }
 
 func (m OneOrMoreMatcher) Match(r rune) bool {
@@ -67,17 +208,56 @@ func (m OneOrMoreMatcher) Match(r rune) bool {
 // ZeroOrOneMatcher matches the underlying pattern zero or one time
 type ZeroOrOneMatcher struct {
 	matcher PatternElement
+	mode    quantifierMode
 }
 
 func (m ZeroOrOneMatcher) Match(r rune) bool {
 	return m.matcher.Match(r)
 }
 
-// WildcardMatcher matches any single character except newline
-type WildcardMatcher struct{}
+// ZeroOrMoreMatcher matches the underlying pattern any number of times
+type ZeroOrMoreMatcher struct {
+	matcher PatternElement
+	mode    quantifierMode
+}
+
+func (m ZeroOrMoreMatcher) Match(r rune) bool {
+	return m.matcher.Match(r)
+}
+
+// MaxRepeat is the default for ParseOptions.MaxRepeat, the largest bound
+// accepted in a counted repetition such as a{2,5}. Each repetition is expanded
+// when the pattern is compiled, so larger bounds would make the compiled
+// program grow without limit.
+const MaxRepeat = 1000
+
+// MaxProgramSize is the default for ParseOptions.MaxProgramSize, the largest
+// number of instructions that a pattern may compile to. It catches
+// repetitions that are within MaxRepeat on their own but multiply when
+// nested, as in (a{1000}){1000}.
+const MaxProgramSize = 100000
+
+// RepeatMatcher matches the underlying pattern between min and max times; a
+// negative max means there is no upper bound
+type RepeatMatcher struct {
+	matcher PatternElement
+	min     int
+	max     int
+	mode    quantifierMode
+}
+
+func (m RepeatMatcher) Match(r rune) bool {
+	return m.matcher.Match(r)
+}
+
+// WildcardMatcher matches any single character except newline, or any at all
+// in dot-all mode; it is also used for \N, which is never dot-all
+type WildcardMatcher struct {
+	dotAll bool
+}
 
 func (m WildcardMatcher) Match(r rune) bool {
-	return r != '\n'
+	return m.dotAll || r != '\n'
 }
 
 // AlternationMatcher matches one of several alternative patterns
@@ -86,13 +266,15 @@ type AlternationMatcher struct {
 }
 
 func (m AlternationMatcher) Match(r rune) bool {
-	// This won't actually be called since we handle alternation specially in matchHere
+	// Not used directly; alternation is compiled into splits
 	return false
 }
 
-// GroupMatcher represents a capturing group; index is 1-based
+// GroupMatcher represents a group; index is 1-based for a capturing group,
+// and 0 for a non-capturing (?:...) group
 type GroupMatcher struct {
 	index   int
+	name    string // set for a named group such as (?P<name>...)
 	pattern *Pattern
 }
 
@@ -101,558 +283,125 @@ func (m GroupMatcher) Match(r rune) bool {
 	return false
 }
 
-// BackReferenceMatcher matches the previously captured group text
-type BackReferenceMatcher struct {
-	index int
+// AtomicGroupMatcher represents an atomic group (?>...): once its pattern has
+// matched, the rest of the pattern cannot backtrack into it to try another way
+type AtomicGroupMatcher struct {
+	pattern *Pattern
 }
 
-func (m BackReferenceMatcher) Match(r rune) bool {
-	// Not used directly; matching compares substrings
+func (m AtomicGroupMatcher) Match(r rune) bool {
+	// Not used directly; matching uses the group's pattern
 	return false
 }
 
-// parseAlternatives parses a string containing alternatives separated by |
-func parseAlternatives(pattern string, groupCounter *int) ([]*Pattern, error) {
-	var alternatives []*Pattern
-	var depth int
-
-	// Split the pattern into alternatives, but only at top level
-	runes := []rune(pattern)
-	start := 0
-
-	for i := 0; i < len(runes); i++ {
-		switch runes[i] {
-		case '(':
-			depth++
-		case ')':
-			depth--
-		case '|':
-			if depth == 0 {
-				// Found a top-level alternation
-				alt, err := parsePatternInternal(string(runes[start:i]), groupCounter)
-				if err != nil {
-					return nil, err
-				}
-				alternatives = append(alternatives, alt)
-				start = i + 1
-			}
-		}
-	}
-
-	// Add the final alternative
-	if start < len(runes) {
-		alt, err := parsePatternInternal(string(runes[start:]), groupCounter)
-		if err != nil {
-			return nil, err
-		}
-		alternatives = append(alternatives, alt)
-	}
-
-	return alternatives, nil
+// LookaroundMatcher matches, without consuming input, where its pattern does
+// (or, when negated, does not) match straight after the current position, or
+// for a lookbehind straight before it. A lookbehind's pattern is limited to
+// between minLen and maxLen runes.
+type LookaroundMatcher struct {
+	pattern *Pattern
+	behind  bool
+	negated bool
+	minLen  int
+	maxLen  int
 }
 
-// ParsePattern converts a pattern string into a sequence of pattern elements
-// parsePatternInternal parses a pattern and updates groupCounter for capturing groups
-func parsePatternInternal(pattern string, groupCounter *int) (*Pattern, error) {
-	var elements []PatternElement
-	runes := []rune(pattern)
-	startAnchor := false
-	endAnchor := false
+func (m LookaroundMatcher) Match(r rune) bool {
+	// Not used directly; matching runs the lookaround's pattern
+	return false
+}
 
-	// Check for start anchor
-	if len(runes) > 0 && runes[0] == '^' {
-		startAnchor = true
-		runes = runes[1:] // Remove the anchor from the pattern
-	}
+// BackReferenceMatcher matches the previously captured group text, ignoring
+// case if caseless is set
+type BackReferenceMatcher struct {
+	index    int
+	caseless bool
+}
 
-	// Check for end anchor
-	if len(runes) > 0 && runes[len(runes)-1] == '$' {
-		endAnchor = true
-		runes = runes[:len(runes)-1] // Remove the end anchor
-	}
+func (m BackReferenceMatcher) Match(r rune) bool {
+	// Not used directly; matching compares substrings
+	return false
+}
 
-	for i := 0; i < len(runes); i++ {
-		r := runes[i]
-
-		switch r {
-		case '(':
-			// This is a capturing group: assign group index
-			(*groupCounter)++
-			groupIndex := *groupCounter
-
-			// Find matching closing parenthesis
-			start := i + 1
-			depth := 1
-			for i++; i < len(runes) && depth > 0; i++ {
-				if runes[i] == '(' {
-					depth++
-				} else if runes[i] == ')' {
-					depth--
-				}
-			}
-			i-- // Step back to the closing parenthesis
-
-			if depth > 0 {
-				// Mismatched parentheses
-				return nil, fmt.Errorf("missing closing parenthesis")
-			}
-
-			// Parse the content within the parentheses (may include alternation)
-			innerPatternStr := string(runes[start:i])
-			var inner *Pattern
-			// Parse alternatives inside group
-			alts, err := parseAlternatives(innerPatternStr, groupCounter)
-			if err != nil {
-				return nil, err
-			}
-			if len(alts) == 1 {
-				inner = alts[0]
-			} else {
-				// Build a pattern whose single element is an AlternationMatcher
-				alternation := AlternationMatcher{alternatives: alts}
-				inner = &Pattern{elements: []PatternElement{alternation}}
-			}
-			if inner != nil {
-				inner.groupCount = *groupCounter
-			}
-
-			element := GroupMatcher{index: groupIndex, pattern: inner}
-
-			// Check for quantifiers after the parentheses
-			if i+1 < len(runes) {
-				switch runes[i+1] {
-				case '+':
-					i++
-					elements = append(elements, OneOrMoreMatcher{matcher: element})
-				case '?':
-					i++
-					elements = append(elements, ZeroOrOneMatcher{matcher: element})
-				default:
-					elements = append(elements, element)
-				}
-			} else {
-				elements = append(elements, element)
-			}
-
-		case '.':
-			element := WildcardMatcher{}
-			// Check for quantifiers
-			if i+1 < len(runes) {
-				switch runes[i+1] {
-				case '+':
-					i++
-					elements = append(elements, OneOrMoreMatcher{matcher: element})
-				case '?':
-					i++
-					elements = append(elements, ZeroOrOneMatcher{matcher: element})
-				default:
-					elements = append(elements, element)
-				}
-			} else {
-				elements = append(elements, element)
-			}
-		case '\\':
-			if i+1 >= len(runes) {
-				continue
-			}
-			i++
-			// Backreference if digit follows
-			if runes[i] >= '1' && runes[i] <= '9' {
-				idx := int(runes[i] - '0')
-				element := BackReferenceMatcher{index: idx}
-				// quantifiers are not meaningful on backreferences here; just append
-				elements = append(elements, element)
-				continue
-			}
-
-			var element PatternElement
-			switch runes[i] {
-			case 'd':
-				element = DigitMatcher{}
-			case 'w':
-				element = AlphanumericMatcher{}
-			default:
-				element = LiteralMatcher{char: runes[i]}
-			}
-			// Check for quantifiers
-			if i+1 < len(runes) {
-				switch runes[i+1] {
-				case '+':
-					i++
-					elements = append(elements, OneOrMoreMatcher{matcher: element})
-				case '?':
-					i++
-					elements = append(elements, ZeroOrOneMatcher{matcher: element})
-				default:
-					elements = append(elements, element)
-				}
-			} else {
-				elements = append(elements, element)
-			}
-		case '[':
-			var chars []rune
-			var negated bool
-			i++
-			if i < len(runes) && runes[i] == '^' {
-				negated = true
-				i++
-			}
-			for i < len(runes) && runes[i] != ']' {
-				chars = append(chars, runes[i])
-				i++
-			}
-			element := CharacterSetMatcher{chars: chars, negated: negated}
-			// Check for quantifiers after the character set
-			if i+1 < len(runes) {
-				switch runes[i+1] {
-				case '+':
-					i++
-					elements = append(elements, OneOrMoreMatcher{matcher: element})
-				case '?':
-					i++
-					elements = append(elements, ZeroOrOneMatcher{matcher: element})
-				default:
-					elements = append(elements, element)
-				}
-			} else {
-				elements = append(elements, element)
-			}
-		default:
-			element := LiteralMatcher{char: r}
-			// Check for quantifiers
-			if i+1 < len(runes) {
-				switch runes[i+1] {
-				case '+':
-					i++
-					elements = append(elements, OneOrMoreMatcher{matcher: element})
-				case '?':
-					i++
-					elements = append(elements, ZeroOrOneMatcher{matcher: element})
-				default:
-					elements = append(elements, element)
-				}
-			} else {
-				elements = append(elements, element)
-			}
+// matchAt reports whether captured appears in input at pos, and if so where it ends
+func (m BackReferenceMatcher) matchAt(input []rune, pos int, captured []rune) (int, bool) {
+	for _, c := range captured {
+		if pos >= len(input) || !(input[pos] == c || m.caseless && foldEqual(c, input[pos])) {
+			return 0, false
 		}
+		pos++
 	}
-
-	return &Pattern{elements: elements, startAnchor: startAnchor, endAnchor: endAnchor, groupCount: *groupCounter}, nil
+	return pos, true
 }
 
-// ParsePattern is the public entry that initializes group counting
-func ParsePattern(pattern string) (*Pattern, error) {
-	counter := 0
-	p, err := parsePatternInternal(pattern, &counter)
-	if err != nil {
-		return nil, err
-	}
-	p.groupCount = counter
-	return p, nil
-}
-
-// matchElementOnce attempts to match a single occurrence of element at pos.
-// It returns (matched, newPos, updatedCaptures).
-func matchElementOnce(element PatternElement, input []rune, pos int, captures []string, p *Pattern) (bool, int, []string) {
-	switch e := element.(type) {
-	case GroupMatcher:
-		// Match the group's inner pattern starting at pos
-		cp := make([]string, len(captures))
-		copy(cp, captures)
-
-		// Try to match the inner pattern and maintain nested captures
-		if ok, newPos := e.pattern.matchHereWithCaptures(input, pos, cp); ok {
-			matchEnd := newPos
-			// Store this group's capture
-			cp[e.index-1] = string(input[pos:matchEnd])
-			// Return all captures including nested ones
-			return true, matchEnd, cp
-		}
-		return false, 0, nil
-	case BackReferenceMatcher:
-		if e.index-1 < 0 || e.index-1 >= len(captures) {
-			return false, 0, nil
-		}
-		capStr := captures[e.index-1]
-		if capStr == "" {
-			// No capture yet for this group, can't match
-			return false, 0, nil
-		}
-		capRunes := []rune(capStr)
-		if pos+len(capRunes) > len(input) {
-			// Not enough input remaining to match the captured text
-			return false, 0, nil
-		}
-		// Compare the captured text with input at current position
-		matched := true
-		for i := 0; i < len(capRunes); i++ {
-			if input[pos+i] != capRunes[i] {
-				matched = false
-				break
-			}
-		}
-		if matched {
-			// Return the captures unchanged since backreferences don't create new captures
-			return true, pos + len(capRunes), captures
-		}
-		return false, 0, nil
-	default:
-		// Simple rune-based element
-		if pos >= len(input) {
-			return false, 0, nil
-		}
-		if !element.Match(input[pos]) {
-			return false, 0, nil
-		}
-		return true, pos + 1, captures
-	}
+// SubexpNames returns the names of the capturing groups in p. The name of
+// group i is at index i, so the first element, for the whole match, is always
+// the empty string; so is the name of an unnamed group.
+func (p *Pattern) SubexpNames() []string {
+	return slices.Clone(p.names)
 }
 
 // Match checks if a sequence of runes matches the pattern at any position
 func (p *Pattern) Match(input []rune) bool {
-	if p.startAnchor {
-		captures := make([]string, p.groupCount)
-		ok, _, _ := p.matchHereWithState(input, 0, captures)
-		return ok
-	}
-
-	// Try matching at each position
-	for startPos := 0; startPos <= len(input); startPos++ {
-		captures := make([]string, p.groupCount)
-		if ok, _, _ := p.matchHereWithState(input, startPos, captures); ok {
-			return true
-		}
+	if p.dfa != nil {
+		return p.dfa.match(input, nil)
 	}
-	return false
+	return p.find(input, 0) != nil
 }
 
-// matchHereWithState attempts to match at current position and manages captured groups
-func (p *Pattern) matchHereWithState(input []rune, pos int, captures []string) (bool, int, []string) {
-	if len(p.elements) == 0 {
-		// Empty pattern matches if no end anchor or if we're at end of input
-		if !p.endAnchor || pos == len(input) {
-			return true, pos, captures
-		}
-		return false, pos, captures
+// MatchContext is like Match, but gives up once ctx is done, returning
+// ctx.Err(), or once the match has taken more steps than the MatchLimit p was
+// parsed with, returning ErrMatchLimit
+func (p *Pattern) MatchContext(ctx context.Context, input []rune) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
 	}
-
-	element := p.elements[0]
-	remaining := &Pattern{
-		elements:   p.elements[1:],
-		endAnchor:  p.endAnchor,
-		groupCount: p.groupCount,
+	limit := p.newMatchLimit(ctx)
+	var matched bool
+	if p.dfa != nil {
+		matched = p.dfa.match(input, limit)
+	} else {
+		matched = p.search(input, 0, limit, false).caps != nil
 	}
-
-	switch e := element.(type) {
-	case GroupMatcher:
-		cp := make([]string, len(captures))
-		copy(cp, captures)
-
-		if ok, newPos, groupCaptures := e.pattern.matchHereWithState(input, pos, cp); ok {
-			// Store this group's match
-			groupCaptures[e.index-1] = string(input[pos:newPos])
-			// Try the rest of the pattern with all captures (including nested ones)
-			if ok2, finalPos, finalCaptures := remaining.matchHereWithState(input, newPos, groupCaptures); ok2 {
-				return true, finalPos, finalCaptures
-			}
-		}
-		return false, pos, captures
-
-	case BackReferenceMatcher:
-		if e.index < 1 || e.index > len(captures) {
-			return false, pos, captures
-		}
-		captured := captures[e.index-1]
-		if captured == "" {
-			return false, pos, captures
-		}
-		if pos+len(captured) > len(input) {
-			return false, pos, captures
-		}
-		// Must match exactly what was captured before
-		if string(input[pos:pos+len(captured)]) != captured {
-			return false, pos, captures
-		}
-		// Try remaining pattern after the backreference
-		return remaining.matchHereWithState(input, pos+len(captured), captures)
-
-	case OneOrMoreMatcher:
-		// Must match at least once
-		if ok, newPos, newCaptures := matchElementOnce(e.matcher, input, pos, captures, p); !ok {
-			return false, pos, captures
-		} else {
-			pos = newPos
-			captures = newCaptures
-		}
-
-		// Match as many as possible first
-		currentCaptures := make([]string, len(captures))
-		copy(currentCaptures, captures)
-		currentPos := pos
-
-		for currentPos < len(input) {
-			if ok, newPos, newCp := matchElementOnce(e.matcher, input, currentPos, currentCaptures, p); ok {
-				currentPos = newPos
-				copy(currentCaptures, newCp)
-			} else {
-				break
-			}
-		}
-
-		// Try matching the rest at each position, from longest match to shortest
-		for tryPos := currentPos; tryPos >= pos; tryPos-- {
-			tryCaptures := make([]string, len(captures))
-			copy(tryCaptures, captures)
-			if ok, finalPos, finalCaptures := remaining.matchHereWithState(input, tryPos, tryCaptures); ok {
-				return true, finalPos, finalCaptures
-			}
-		}
-		return false, pos, captures
-
-	case ZeroOrOneMatcher:
-		// Try skipping first
-		if ok, newPos, newCaptures := remaining.matchHereWithState(input, pos, captures); ok {
-			return true, newPos, newCaptures
-		}
-		// Try matching once
-		if ok, newPos, newCaptures := matchElementOnce(e.matcher, input, pos, captures, p); ok {
-			return remaining.matchHereWithState(input, newPos, newCaptures)
-		}
-		return false, pos, captures
-
-	case AlternationMatcher:
-		for _, alt := range e.alternatives {
-			if ok, newPos, newCaptures := alt.matchHereWithState(input, pos, captures); ok {
-				return remaining.matchHereWithState(input, newPos, newCaptures)
-			}
-		}
-		return false, pos, captures
-
-	default:
-		if ok, newPos, newCaptures := matchElementOnce(element, input, pos, captures, p); ok {
-			return remaining.matchHereWithState(input, newPos, newCaptures)
-		}
-		return false, pos, captures
+	if limit.err != nil {
+		return false, limit.err
 	}
+	return matched, nil
 }
 
-// matchHere attempts to match the pattern starting at the given position
-// matchHereWithCaptures attempts to match the pattern starting at pos using captures.
-// It returns (matched, newPos). captures is mutated on successful paths.
-func (p *Pattern) matchHereWithCaptures(input []rune, pos int, captures []string) (bool, int) {
-	patternPos := 0
-	inputPos := pos
-
-	for patternPos < len(p.elements) {
-		element := p.elements[patternPos]
-
-		switch q := element.(type) {
-		case AlternationMatcher:
-			remainingPattern := &Pattern{
-				elements:   p.elements[patternPos+1:],
-				endAnchor:  p.endAnchor,
-				groupCount: p.groupCount,
-			}
-
-			for _, alt := range q.alternatives {
-				// Try this alternative
-				cp := make([]string, len(captures))
-				copy(cp, captures)
-
-				// First match the alternative
-				if ok, altPos := alt.matchHereWithCaptures(input, inputPos, cp); ok {
-					// Then try to match the remaining pattern
-					if ok2, finalPos := remainingPattern.matchHereWithCaptures(input, altPos, cp); ok2 {
-						copy(captures, cp)
-						return true, finalPos
-					}
-				}
-			}
-			return false, 0
-
-		case OneOrMoreMatcher:
-			// Need at least one match
-			cp := make([]string, len(captures))
-			copy(cp, captures)
-			ok, newPos, newCp := matchElementOnce(q.matcher, input, inputPos, cp, p)
-			if !ok {
-				return false, 0
-			}
-			inputPos = newPos
-			copy(cp, newCp)
-
-			// Try matching the remaining pattern after each repetition
-			remainingPattern := &Pattern{
-				elements:   p.elements[patternPos+1:],
-				endAnchor:  p.endAnchor,
-				groupCount: p.groupCount,
-			}
-
-			// Keep trying to consume more occurrences while attempting the remainder
-			for {
-				// Try the remaining pattern at current position
-				tryCp := make([]string, len(cp))
-				copy(tryCp, cp)
-				if ok, newPosRem := remainingPattern.matchHereWithCaptures(input, inputPos, tryCp); ok {
-					copy(captures, tryCp)
-					return true, newPosRem
-				}
-
-				// Try one more occurrence
-				ok2, nextPos, nextCp := matchElementOnce(q.matcher, input, inputPos, cp, p)
-				if !ok2 {
-					break
-				}
-				inputPos = nextPos
-				copy(cp, nextCp)
-			}
-			return false, 0
-
-		case ZeroOrOneMatcher:
-			remainingPattern := &Pattern{
-				elements:   p.elements[patternPos+1:],
-				endAnchor:  p.endAnchor,
-				groupCount: p.groupCount,
-			}
-
-			// First try zero occurrences (skip the element)
-			cpZero := make([]string, len(captures))
-			copy(cpZero, captures)
-			if ok, newPos := remainingPattern.matchHereWithCaptures(input, inputPos, cpZero); ok {
-				copy(captures, cpZero)
-				return true, newPos
-			}
-
-			// Then try matching one occurrence
-			cp := make([]string, len(captures))
-			copy(cp, captures)
-			ok, newPos, newCp := matchElementOnce(q.matcher, input, inputPos, cp, p)
-			if ok {
-				// Try to match the remainder after this occurrence
-				if ok2, newPos2 := remainingPattern.matchHereWithCaptures(input, newPos, newCp); ok2 {
-					copy(captures, newCp)
-					return true, newPos2
-				}
-			}
-			return false, 0
-
-		default:
-			// Normal (non-quantified) element: attempt to match once
-			ok, newPos, newCp := matchElementOnce(element, input, inputPos, captures, p)
-			if !ok {
-				return false, 0
-			}
-			// update captures and advance
-			copy(captures, newCp)
-			inputPos = newPos
-			patternPos++
-			continue
-		}
-	}
+// Longest makes future searches with p prefer, among the matches that start
+// leftmost, the longest one, as POSIX specifies for egrep. By default the
+// first match found wins, trying alternatives from left to right and
+// quantifiers from the preferred number of repetitions, as in Perl. Groups
+// then capture following the POSIX subexpression rules: from left to right,
+// each group starts as early and then matches as much as the overall match
+// allows. In a pattern that needs backtracking, such as one with a
+// backreference, only the overall match is made longest.
+func (p *Pattern) Longest() {
+	p.longest = true
+}
+
+// find returns the capture slots, in rune offsets, of the leftmost match that
+// starts at or after start, or nil if there is none. A match that goes over
+// p's MatchLimit is given up as no match.
+func (p *Pattern) find(input []rune, start int) []int {
+	return p.search(input, start, p.newMatchLimit(nil), false).caps
+}
 
-	// If we have an end anchor, ensure we've reached the end of the input
-	if p.endAnchor {
-		return inputPos == len(input), inputPos
+// search runs the engine that suits p from start, with each thread keeping a
+// log of every capture if history is set, and returns the matching thread
+func (p *Pattern) search(input []rune, start int, limit *matchLimit, history bool) thread {
+	switch {
+	case p.prog.backtrack:
+		b := newBacktracker(p.prog, input, p.longest)
+		b.limit, b.history = limit, history
+		return b.run(start)
+	case p.longest:
+		m := newPosixVM(p.prog, input)
+		m.limit, m.history = limit, history
+		return m.run(start)
 	}
-	return true, inputPos
+	m := newPikeVM(p.prog, input)
+	m.limit, m.history = limit, history
+	return m.run(start)
 }
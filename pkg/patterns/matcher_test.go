@@ -0,0 +1,171 @@
+package patterns
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestLinebreakIsAtomic(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    bool
+	}{
+		{`^\R$`, "\r\n", true},
+		{`^\R{2}$`, "\r\n", false},
+		{`^\R\R$`, "\r\n", false},
+		{`^\R\R$`, "\n\r", true},
+		{`^\R\R$`, "\r\r", true},
+		{`^\R\R$`, "\r\n\r\n", true},
+		{`^\R+$`, "\r\n\n\r", true},
+		{`^\R\n$`, "\r\n", false},
+		{`^\r\R$`, "\r\n", true},
+		{`^\R{3}$`, "\r\n\r\n\r", true},
+		{`^(\R)(\R)$`, "\r\n", false},
+	}
+	for _, tt := range tests {
+		p, err := ParsePattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", tt.pattern, err)
+		}
+		longest, _ := ParsePattern(tt.pattern, ParseOptions{Longest: true})
+		input := []rune(tt.input)
+		engines := map[string]func() bool{
+			"dfa":         func() bool { return p.Match(input) },
+			"pike":        func() bool { return p.Find(input) != nil },
+			"backtracker": func() bool { return withBacktracker(p).Find(input) != nil },
+			"longest":     func() bool { return longest.Find(input) != nil },
+		}
+		for name, match := range engines {
+			if got := match(); got != tt.want {
+				t.Errorf("%s: %q on %q: got %v, want %v", name, tt.pattern, tt.input, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestBackReferences(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    []int // capture slots in runes, nil for no match
+	}{
+		{`(a)(b)\2`, "abb", []int{0, 3, 0, 1, 1, 2}},
+		{`(a)(b)\g{2}`, "abb", []int{0, 3, 0, 1, 1, 2}},
+		{`(a)(b)\g2`, "abb", []int{0, 3, 0, 1, 1, 2}},
+		{`(a)(b)\g{-1}`, "abb", []int{0, 3, 0, 1, 1, 2}},
+		{`(a)(b)\g{-2}`, "aba", []int{0, 3, 0, 1, 1, 2}},
+		{`(a)(b)\g-2`, "aba", []int{0, 3, 0, 1, 1, 2}},
+		{`(a)(b)\g-2`, "abb", nil},
+		// Relative references count back from the group last opened
+		{`(a)\g{-1}(b)\g{-1}`, "aabb", []int{0, 4, 0, 1, 2, 3}},
+		{`(?<x>a)\g{x}`, "aa", []int{0, 2, 0, 1}},
+		// Every digit belongs to the reference
+		{`(a)(b)(c)(d)(e)(f)(g)(h)(i)(j)\10`, "abcdefghijj",
+			[]int{0, 11, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10}},
+		{`(a)(b)(c)(d)(e)(f)(g)(h)(i)(j)\10`, "abcdefghija0", nil},
+		{`(ab)\1+`, "xababab", []int{1, 7, 1, 3}},
+		{`(ab)\1+`, "ab", nil},
+		// A group that captured empty text makes its reference match empty
+		{`(a|)\1b`, "b", []int{0, 1, 0, 0}},
+		{`(a)?\1b`, "b", nil},
+	}
+	for _, tt := range tests {
+		p, err := ParsePattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", tt.pattern, err)
+		}
+		var got []int
+		for _, span := range p.FindSubmatchIndex([]rune(tt.input)) {
+			got = append(got, span.Start, span.End)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q on %q: got %v, want %v", tt.pattern, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestBackReferenceErrors(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		offset   int
+		fragment string
+	}{
+		{`(a)\g{0}`, 3, `\g{0}`},
+		{`\g{-2}(a)`, 0, `\g{-2}`},
+		{`(a)\g{-2}`, 3, `\g{-2}`},
+		{`(a)\g2`, 3, `\g2`},
+		{`(a)\10`, 3, `\10`},
+		{`\1(a)`, 0, `\1`},
+		{`(a)\g{b}`, 3, `\g{b}`},
+	}
+	for _, tt := range tests {
+		_, err := ParsePattern(tt.pattern)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("ParsePattern(%q): got %v, want a *ParseError", tt.pattern, err)
+			continue
+		}
+		want := ParseError{Code: ErrInvalidBackReference, Offset: tt.offset, Fragment: tt.fragment}
+		if *parseErr != want {
+			t.Errorf("ParsePattern(%q): got %#v, want %#v", tt.pattern, *parseErr, want)
+		}
+	}
+}
+
+func TestSubexpNames(t *testing.T) {
+	p, err := ParsePattern(`(?P<a>x)(y)(?<b>y)(?:z)(?>w)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"", "a", "", "b"}
+	if got := p.SubexpNames(); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// The result is a copy
+	p.SubexpNames()[1] = "changed"
+	if got := p.SubexpNames(); got[1] != "a" {
+		t.Errorf("got %q after changing a copy, want %q", got[1], "a")
+	}
+
+	p, _ = ParsePattern(`a(b)`)
+	if got := p.SubexpNames(); !slices.Equal(got, []string{"", ""}) {
+		t.Errorf("got %q, want two empty names", got)
+	}
+}
+
+func TestNamedBackReferences(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    []int // capture slots in runes, nil for no match
+	}{
+		{`(?<n>a)\k<n>`, "aa", []int{0, 2, 0, 1}},
+		{`(?<n>a)\k<n>`, "ab", nil},
+		{`(?P<word>\w+) \k<word>`, "say bye bye", []int{4, 11, 4, 7}},
+		{`(x)(?<n>a|b)\k<n>`, "xbb", []int{0, 3, 0, 1, 1, 2}},
+		{`(?<n>a)\g{n}`, "aa", []int{0, 2, 0, 1}},
+	}
+	for _, tt := range tests {
+		p, err := ParsePattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", tt.pattern, err)
+		}
+		var got []int
+		for _, span := range p.FindSubmatchIndex([]rune(tt.input)) {
+			got = append(got, span.Start, span.End)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q on %q: got %v, want %v", tt.pattern, tt.input, got, tt.want)
+		}
+	}
+
+	for _, pattern := range []string{`\k<n>(?<n>a)`, `(?<n>a)\k<m>`, `(a)\k<1>`} {
+		_, err := ParsePattern(pattern)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || parseErr.Code != ErrInvalidBackReference {
+			t.Errorf("ParsePattern(%q): got %v, want %q", pattern, err, ErrInvalidBackReference)
+		}
+	}
+}
@@ -0,0 +1,1088 @@
+package patterns
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ParseOptions changes how ParsePattern interprets a pattern
+type ParseOptions struct {
+	// ASCIIClasses restricts POSIX classes such as [[:alpha:]] to ASCII
+	// characters, as in the C locale. By default they are Unicode-aware.
+	ASCIIClasses bool
+
+	// CaseInsensitive matches letters regardless of case, like (?i)
+	CaseInsensitive bool
+	// Multiline makes ^ and $ match at the start and end of every line rather
+	// than only of the input, like (?m)
+	Multiline bool
+	// DotAll lets . match a newline, like (?s)
+	DotAll bool
+	// Extended ignores unescaped whitespace outside bracket expressions and
+	// treats # as the start of a comment running to the end of the line, like (?x)
+	Extended bool
+
+	// Longest selects POSIX leftmost-longest matching; see Pattern.Longest
+	Longest bool
+
+	// MatchLimit bounds the steps that a single match may take in a pattern
+	// that needs backtracking, such as one with a backreference, whose
+	// matches can otherwise take exponential time; zero means no limit. Other
+	// patterns are matched in linear time and are not limited. A match that
+	// goes over the limit is given up: MatchContext returns ErrMatchLimit,
+	// and the other methods report no match.
+	MatchLimit int
+
+	// MaxRepeat is the largest bound accepted in a counted repetition such as
+	// a{2,5}; zero means the package default, MaxRepeat
+	MaxRepeat int
+	// MaxProgramSize is the largest number of instructions that the pattern
+	// may compile to; zero means the package default, MaxProgramSize
+	MaxProgramSize int
+}
+
+// setFlag turns the option for an inline flag letter on or off, reporting
+// whether the letter names a flag
+func (o *ParseOptions) setFlag(flag rune, on bool) bool {
+	switch flag {
+	case 'i':
+		o.CaseInsensitive = on
+	case 'm':
+		o.Multiline = on
+	case 's':
+		o.DotAll = on
+	case 'x':
+		o.Extended = on
+	default:
+		return false
+	}
+	return true
+}
+
+// maxRepeat returns the largest repetition bound that o accepts
+func (o *ParseOptions) maxRepeat() int {
+	if o.MaxRepeat > 0 {
+		return o.MaxRepeat
+	}
+	return MaxRepeat
+}
+
+// maxProgramSize returns the largest compiled size that o accepts
+func (o *ParseOptions) maxProgramSize() int {
+	if o.MaxProgramSize > 0 {
+		return o.MaxProgramSize
+	}
+	return MaxProgramSize
+}
+
+// parseState is shared by every level of a single ParsePattern call
+type parseState struct {
+	groupCount int      // number of capturing groups opened so far
+	names      []string // name of each capturing group, indexed from 1
+	options    ParseOptions
+}
+
+// literal returns the matcher for r under the current flags
+func (state *parseState) literal(r rune) LiteralMatcher {
+	return LiteralMatcher{char: r, caseless: state.options.CaseInsensitive}
+}
+
+// parseAlternation parses a pattern that may contain top-level alternatives
+// separated by |. A single alternative is returned as is; several are wrapped
+// in a pattern whose only element is an AlternationMatcher. offset is the
+// position of pattern within the string given to ParsePattern.
+func parseAlternation(pattern string, offset int, state *parseState) (*Pattern, error) {
+	alts, err := parseAlternatives(pattern, offset, state)
+	if err != nil {
+		return nil, err
+	}
+	if len(alts) == 1 {
+		return alts[0], nil
+	}
+	return &Pattern{
+		elements: []PatternElement{AlternationMatcher{alternatives: alts}},
+		source:   []sourceRange{{offset, offset + len([]rune(pattern))}},
+	}, nil
+}
+
+// parseAlternatives parses a string containing alternatives separated by |
+func parseAlternatives(pattern string, offset int, state *parseState) ([]*Pattern, error) {
+	var alternatives []*Pattern
+	var err error
+
+	// Split the pattern into alternatives, but only at top level
+	runes := []rune(pattern)
+	start := 0
+	scanSyntax(runes, 0, state.options.Extended, func(i, depth int) bool {
+		if runes[i] != '|' || depth != 0 {
+			return true
+		}
+		// Found a top-level alternation
+		var alt *Pattern
+		if alt, err = parsePatternInternal(string(runes[start:i]), offset+start, state); err != nil {
+			return false
+		}
+		alternatives = append(alternatives, alt)
+		start = i + 1
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Add the final alternative, which may be empty as in "a|"
+	alt, err := parsePatternInternal(string(runes[start:]), offset+start, state)
+	if err != nil {
+		return nil, err
+	}
+	alternatives = append(alternatives, alt)
+
+	return alternatives, nil
+}
+
+// scanSyntax calls visit with the index of each (, ) and | from runes[start]
+// on that structures the pattern, together with the number of groups open
+// around it, not counting the group a parenthesis opens or closes, until visit
+// returns false. It steps over escapes, quotations and bracket expressions,
+// and in extended mode over comments, following the flag groups that turn
+// extended mode on and off as the parser does.
+func scanSyntax(runes []rune, start int, extended bool, visit func(i, depth int) bool) {
+	// The extended mode of each group open around the current rune
+	modes := []bool{extended}
+	depth := 0
+	for i := start; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case r == '\\' || r == '[':
+			i = skipEscapeOrSet(runes, i)
+		case r == '#' && modes[len(modes)-1]:
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '(':
+			if !visit(i, depth) {
+				return
+			}
+			mode, end, ok := scanFlagGroup(runes, i, modes[len(modes)-1])
+			if ok && runes[end] == ')' {
+				// (?x) lasts until the end of the enclosing group
+				modes[len(modes)-1] = mode
+				if !visit(end, depth) {
+					return
+				}
+				i = end
+				continue
+			}
+			depth++
+			modes = append(modes, mode)
+		case r == ')':
+			depth--
+			if len(modes) > 1 {
+				modes = modes[:len(modes)-1]
+			}
+			if !visit(i, depth) {
+				return
+			}
+		case r == '|':
+			if !visit(i, depth) {
+				return
+			}
+		}
+	}
+}
+
+// scanFlagGroup returns the extended mode inside the group that opens at
+// runes[open], given the mode outside it. If the group is a flag group such as
+// (?x) or (?-x:...), it also returns the index of the ) or : that ends its
+// flags, and true.
+func scanFlagGroup(runes []rune, open int, extended bool) (bool, int, bool) {
+	if open+2 >= len(runes) || runes[open+1] != '?' || runes[open+2] != '-' && !strings.ContainsRune("imsx", runes[open+2]) {
+		return extended, 0, false
+	}
+	on := true
+	for i := open + 2; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case r == ')' || r == ':':
+			return extended, i, true
+		case r == '-':
+			on = false
+		case r == 'x':
+			extended = on
+		case !strings.ContainsRune("ims", r):
+			return extended, 0, false
+		}
+	}
+	return extended, 0, false
+}
+
+// skipEscapeOrSet returns the index of the last rune of the escape sequence,
+// \Q...\E quotation or bracket expression starting at runes[i], so that
+// scanners looking for parentheses and | can step over ones that are quoted
+func skipEscapeOrSet(runes []rune, i int) int {
+	switch runes[i] {
+	case '\\':
+		if i+1 < len(runes) && runes[i+1] == 'Q' {
+			end, _ := quoteEnd(runes, i+2)
+			return end
+		}
+		if i+1 < len(runes) {
+			return i + 1
+		}
+	case '[':
+		if end, ok := findSetEnd(runes, i); ok {
+			return end
+		}
+		return len(runes) - 1
+	}
+	return i
+}
+
+// quoteEnd returns the index of the E of the \E that ends the quotation whose
+// text starts at runes[start], or if there is no \E, of the last rune and false
+func quoteEnd(runes []rune, start int) (int, bool) {
+	for i := start; i+1 < len(runes); i++ {
+		if runes[i] == '\\' && runes[i+1] == 'E' {
+			return i + 1, true
+		}
+	}
+	return len(runes) - 1, false
+}
+
+// findSetEnd returns the index of the ] closing the bracket expression that
+// opens at runes[start]. A ] straight after the opening [ or [^, one escaped
+// with a backslash, or one inside a [:class:], [=equivalence=] or [.symbol.]
+// term is a member of the set rather than its end.
+func findSetEnd(runes []rune, start int) (int, bool) {
+	i := start + 1
+	if i < len(runes) && runes[i] == '^' {
+		i++
+	}
+	if i < len(runes) && runes[i] == ']' {
+		i++
+	}
+	for ; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			i++
+		case '[':
+			if end, ok := findSetTermEnd(runes, i); ok {
+				i = end
+			}
+		case ']':
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// findSetTermEnd returns the index of the final ] of the [:class:],
+// [=equivalence=] or [.symbol.] term that opens at runes[start], if there is one
+func findSetTermEnd(runes []rune, start int) (int, bool) {
+	if start+1 >= len(runes) {
+		return 0, false
+	}
+	delim := runes[start+1]
+	if delim != ':' && delim != '=' && delim != '.' {
+		return 0, false
+	}
+	for i := start + 2; i+1 < len(runes); i++ {
+		if runes[i] == delim && runes[i+1] == ']' {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// parseCharacterSet parses the bracket expression that opens at runes[start],
+// returning it together with the index of its closing ]. Members are single
+// runes, ranges such as a-z, shorthand classes such as \d, and the POSIX terms
+// [:class:], [=equivalence=] and [.symbol.]; a backslash makes any other rune
+// literal, including ], - and \ itself.
+func parseCharacterSet(runes []rune, start, offset int, state *parseState) (CharacterSetMatcher, int, error) {
+	end, ok := findSetEnd(runes, start)
+	if !ok {
+		return CharacterSetMatcher{}, 0, newParseError(ErrMissingBracket, runes, start, len(runes), offset)
+	}
+	if end-start > 2 && runes[start+1] == ':' && runes[end-1] == ':' {
+		// Almost certainly [:space:] written where [[:space:]] was meant
+		return CharacterSetMatcher{}, 0, newParseError(ErrBareCharClass, runes, start, end+1, offset)
+	}
+
+	var ranges []runeRange
+	var classes []PatternElement
+	negated := false
+	i := start + 1
+	if runes[i] == '^' {
+		negated = true
+		i++
+	}
+
+	for i < end {
+		itemStart := i
+		lo, class, next, err := parseSetMember(runes, i, offset, state)
+		if err != nil {
+			return CharacterSetMatcher{}, 0, err
+		}
+		i = next
+		if set, ok := class.(CharacterSetMatcher); ok && !set.negated && len(set.classes) == 0 {
+			// Plain ranges, such as an ASCII POSIX class, join the range table
+			ranges = append(ranges, set.ranges...)
+			continue
+		}
+		if class != nil {
+			classes = append(classes, class)
+			continue
+		}
+
+		// A - between two members forms a range; first or last in the set it is literal
+		if i+1 < end && runes[i] == '-' {
+			hi, class, next, err := parseSetMember(runes, i+1, offset, state)
+			if err != nil {
+				return CharacterSetMatcher{}, 0, err
+			}
+			if class != nil || hi < lo {
+				return CharacterSetMatcher{}, 0, newParseError(ErrInvalidCharRange, runes, itemStart, next, offset)
+			}
+			ranges = append(ranges, runeRange{lo: lo, hi: hi})
+			i = next
+			continue
+		}
+		ranges = append(ranges, runeRange{lo: lo, hi: lo})
+	}
+
+	set := newCharacterSet(ranges, classes, negated)
+	set.caseless = state.options.CaseInsensitive
+	return set, end, nil
+}
+
+// parseSetMember parses the single member of a bracket expression at runes[i],
+// which is either a rune or a class. It returns the index just past it.
+func parseSetMember(runes []rune, i, offset int, state *parseState) (rune, PatternElement, int, error) {
+	switch runes[i] {
+	case '\\':
+		if runes[i+1] == 'p' || runes[i+1] == 'P' {
+			class, next, err := parseUnicodeClass(runes, i, offset)
+			return 0, class, next, err
+		}
+		if class, ok := classEscape(runes[i+1]); ok {
+			return 0, class, i + 2, nil
+		}
+		if runes[i+1] == 'R' {
+			// A line break may be two runes long, so it cannot be a set member
+			return 0, nil, 0, newParseError(ErrInvalidEscape, runes, i, i+2, offset)
+		}
+		if runes[i+1] == 'b' {
+			// Inside brackets \b is a backspace rather than a word boundary
+			return '\b', nil, i + 2, nil
+		}
+		r, next, ok, err := parseCharEscape(runes, i, offset)
+		if err != nil {
+			return 0, nil, 0, err
+		}
+		if !ok {
+			return 0, nil, 0, newParseError(ErrInvalidEscape, runes, i, i+2, offset)
+		}
+		return r, nil, next, nil
+
+	case '[':
+		end, ok := findSetTermEnd(runes, i)
+		if !ok {
+			return runes[i], nil, i + 1, nil
+		}
+		body := runes[i+2 : end-1]
+		if runes[i+1] == ':' {
+			class, ok := newPosixClass(string(body), state.options.ASCIIClasses)
+			if !ok {
+				return 0, nil, 0, newParseError(ErrInvalidCharClass, runes, i, end+1, offset)
+			}
+			return 0, class, end + 1, nil
+		}
+
+		// In the C locale every collating element is a single character, and
+		// every character is alone in its equivalence class
+		if len(body) != 1 {
+			return 0, nil, 0, newParseError(ErrInvalidCollatingElement, runes, i, end+1, offset)
+		}
+		if runes[i+1] == '=' {
+			// An equivalence class cannot be the end point of a range
+			return 0, newCharacterSet([]runeRange{{lo: body[0], hi: body[0]}}, nil, false), end + 1, nil
+		}
+		return body[0], nil, end + 1, nil
+
+	default:
+		return runes[i], nil, i + 1, nil
+	}
+}
+
+// classEscape returns the matcher for the shorthand class written as a
+// backslash followed by r, such as \d or \S
+func classEscape(r rune) (PatternElement, bool) {
+	switch r {
+	case 'd', 'D':
+		return DigitMatcher{negated: r == 'D'}, true
+	case 'w', 'W':
+		return AlphanumericMatcher{negated: r == 'W'}, true
+	case 's', 'S':
+		return WhitespaceMatcher{negated: r == 'S'}, true
+	case 'h', 'H':
+		return HorizontalSpaceMatcher{negated: r == 'H'}, true
+	case 'v', 'V':
+		return VerticalSpaceMatcher{negated: r == 'V'}, true
+	case 'N':
+		return WildcardMatcher{}, true
+	default:
+		return nil, false
+	}
+}
+
+// parseUnicodeClass parses the Unicode class escape \pX, \p{Name} or
+// \p{^Name} (or its negation with \P) whose backslash is at runes[start], and
+// returns it with the index just past it
+func parseUnicodeClass(runes []rune, start, offset int) (PatternElement, int, error) {
+	negated := runes[start+1] == 'P'
+	i := start + 2
+	if i >= len(runes) {
+		return nil, 0, newParseError(ErrInvalidUnicodeClass, runes, start, i, offset)
+	}
+
+	var name string
+	end := i + 1
+	if runes[i] == '{' {
+		for end < len(runes) && runes[end] != '}' {
+			end++
+		}
+		if end == len(runes) {
+			return nil, 0, newParseError(ErrInvalidUnicodeClass, runes, start, end, offset)
+		}
+		name = string(runes[i+1 : end])
+		end++
+		if rest, ok := strings.CutPrefix(name, "^"); ok {
+			name = rest
+			negated = !negated
+		}
+	} else {
+		name = string(runes[i])
+	}
+
+	table, ok := unicodeTable(name)
+	if !ok {
+		return nil, 0, newParseError(ErrInvalidUnicodeClass, runes, start, end, offset)
+	}
+	return UnicodeClassMatcher{name: name, table: table, negated: negated}, end, nil
+}
+
+// isQuantifierStart reports whether r begins a quantifier
+func isQuantifierStart(r rune) bool {
+	return r == '*' || r == '+' || r == '?' || r == '{'
+}
+
+// parseQuantifier checks whether the rune after runes[i] starts a quantifier
+// and if so wraps element in the matching repetition. A trailing ? makes the
+// quantifier lazy and a trailing + makes it possessive. In extended mode
+// whitespace and comments may come before the quantifier and its suffix. It
+// returns the element together with the index of the last rune it consumed.
+func parseQuantifier(runes []rune, i, offset int, state *parseState, element PatternElement) (PatternElement, int, error) {
+	// next returns the index of the first rune after runes[j] that is not ignored
+	next := func(j int) int {
+		if state.options.Extended {
+			return skipIgnored(runes, j+1)
+		}
+		return j + 1
+	}
+
+	q := next(i)
+	if q >= len(runes) {
+		return element, i, nil
+	}
+	var minCount, maxCount int
+	end := q
+	switch runes[q] {
+	case '*', '+', '?':
+	case '{':
+		var err error
+		minCount, maxCount, end, err = parseRepeatBounds(runes, q, offset, state.options.maxRepeat())
+		if err != nil {
+			return nil, i, err
+		}
+	default:
+		return element, i, nil
+	}
+
+	mode := greedy
+	if j := next(end); j < len(runes) {
+		switch runes[j] {
+		case '?':
+			mode = lazy
+			end = j
+		case '+':
+			mode = possessive
+			end = j
+		}
+	}
+
+	// A quantifier cannot itself be quantified, as in a** or a{2}{3}
+	if j := next(end); j < len(runes) && isQuantifierStart(runes[j]) {
+		return nil, i, newParseError(ErrInvalidNestedRepeat, runes, q, j+1, offset)
+	}
+
+	var repeated PatternElement
+	switch runes[q] {
+	case '*':
+		repeated = ZeroOrMoreMatcher{matcher: element, mode: mode}
+	case '+':
+		repeated = OneOrMoreMatcher{matcher: element, mode: mode}
+	case '?':
+		repeated = ZeroOrOneMatcher{matcher: element, mode: mode}
+	default:
+		repeated = RepeatMatcher{matcher: element, min: minCount, max: maxCount, mode: mode}
+	}
+	if compiledSize(repeated) > state.options.maxProgramSize() {
+		return nil, i, newParseError(ErrInvalidRepeatSize, runes, q, end+1, offset)
+	}
+	return repeated, end, nil
+}
+
+// skipIgnored returns the index of the first rune from runes[i] on that
+// extended mode does not ignore as whitespace or part of a comment
+func skipIgnored(runes []rune, i int) int {
+	for i < len(runes) {
+		switch {
+		case unicode.IsSpace(runes[i]):
+			i++
+		case runes[i] == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// quantifierBounds returns the element repeated by a quantifier together with
+// its bounds and mode; ok is false if element is not a quantifier
+func quantifierBounds(element PatternElement) (inner PatternElement, minCount, maxCount int, mode quantifierMode, ok bool) {
+	switch e := element.(type) {
+	case ZeroOrMoreMatcher:
+		return e.matcher, 0, -1, e.mode, true
+	case OneOrMoreMatcher:
+		return e.matcher, 1, -1, e.mode, true
+	case ZeroOrOneMatcher:
+		return e.matcher, 0, 1, e.mode, true
+	case RepeatMatcher:
+		return e.matcher, e.min, e.max, e.mode, true
+	default:
+		return nil, 0, 0, greedy, false
+	}
+}
+
+// parseRepeatBounds parses a counted repetition {n}, {n,} or {n,m} whose
+// opening brace is at runes[start]. It returns the bounds, with -1 for a
+// missing upper bound, and the index of the closing brace. Bounds above
+// maxRepeat are rejected.
+func parseRepeatBounds(runes []rune, start, offset, maxRepeat int) (int, int, int, error) {
+	end := start + 1
+	for end < len(runes) && runes[end] != '}' {
+		end++
+	}
+	if end == len(runes) {
+		return 0, 0, 0, newParseError(ErrInvalidRepeatOp, runes, start, end, offset)
+	}
+
+	body := string(runes[start+1 : end])
+	minStr, maxStr, hasComma := strings.Cut(body, ",")
+	minCount, err := parseRepeatCount(minStr)
+	if err != nil {
+		return 0, 0, 0, newParseError(ErrInvalidRepeatOp, runes, start, end+1, offset)
+	}
+	maxCount := minCount
+	if hasComma {
+		maxCount = -1
+		if maxStr != "" {
+			if maxCount, err = parseRepeatCount(maxStr); err != nil {
+				return 0, 0, 0, newParseError(ErrInvalidRepeatOp, runes, start, end+1, offset)
+			}
+		}
+	}
+
+	// Inverted bounds, and bounds above maxRepeat
+	if (maxCount >= 0 && maxCount < minCount) || minCount > maxRepeat || maxCount > maxRepeat {
+		return 0, 0, 0, newParseError(ErrInvalidRepeatSize, runes, start, end+1, offset)
+	}
+	return minCount, maxCount, end, nil
+}
+
+// parseRepeatCount parses a repetition bound, which must be a plain decimal number
+func parseRepeatCount(s string) (int, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, strconv.ErrSyntax
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		// Too large for an int, and so above any repetition limit
+		return math.MaxInt, nil
+	}
+	return n, nil
+}
+
+// parseBackReference parses the backreference starting with the backslash at
+// runes[start]: \N with any number of digits, \g{N} or \gN, \g{-N} or \g-N
+// counting back from the last group opened, \g{name}, or \k<name>. The group
+// must already have been opened. It returns the index of the last rune of the
+// reference.
+func parseBackReference(runes []rune, start, offset int, state *parseState) (PatternElement, int, error) {
+	i := start + 1
+	var ref string
+	end := i
+	switch {
+	case runes[i] == 'k':
+		// \k<name>
+		if i+1 < len(runes) && runes[i+1] == '<' {
+			end = slices.Index(runes[i+1:], '>') + i + 1
+		}
+		if end <= i {
+			return nil, 0, newParseError(ErrInvalidEscape, runes, start, min(i+2, len(runes)), offset)
+		}
+		ref = string(runes[i+2 : end])
+	case runes[i] == 'g' && i+1 < len(runes) && runes[i+1] == '{':
+		end = slices.Index(runes[i+1:], '}') + i + 1
+		if end <= i {
+			return nil, 0, newParseError(ErrInvalidEscape, runes, start, i+2, offset)
+		}
+		ref = string(runes[i+2 : end])
+	default:
+		// \N or \gN, with an optional - after the g
+		if runes[i] == 'g' {
+			i++
+			if i < len(runes) && runes[i] == '-' {
+				i++
+			}
+		}
+		end = i
+		for end < len(runes) && '0' <= runes[end] && runes[end] <= '9' {
+			end++
+		}
+		if end == i {
+			return nil, 0, newParseError(ErrInvalidEscape, runes, start, min(end+1, len(runes)), offset)
+		}
+		ref = string(runes[start+1 : end])
+		if runes[start+1] == 'g' {
+			ref = ref[1:]
+		}
+		end--
+	}
+
+	index := slices.Index(state.names, ref)
+	if n, err := strconv.Atoi(ref); err == nil && runes[start+1] != 'k' && ref[0] != '+' {
+		index = n
+		if n < 0 {
+			// Relative: \g{-1} is the group opened most recently
+			index = state.groupCount + 1 + n
+		}
+	}
+	if index < 1 || index > state.groupCount {
+		return nil, 0, newParseError(ErrInvalidBackReference, runes, start, end+1, offset)
+	}
+	return BackReferenceMatcher{index: index, caseless: state.options.CaseInsensitive}, end, nil
+}
+
+// parseGroup parses the parenthesised group runes[open:close+1]. Plain
+// parentheses capture; a leading ? selects one of the other kinds of group.
+func parseGroup(runes []rune, open, close, offset int, state *parseState) (PatternElement, error) {
+	start := open + 1
+	if start < close && runes[start] == '?' && (runes[start+1] == '-' || strings.ContainsRune("imsx", runes[start+1])) {
+		return parseFlagGroup(runes, open, close, offset, state)
+	}
+
+	// Flags set inside the group end with it
+	saved := state.options
+	defer func() { state.options = saved }()
+
+	if start < close && runes[start] == '?' {
+		var look LookaroundMatcher
+		prefix := string(runes[start:min(start+3, close)])
+		switch {
+		case strings.HasPrefix(prefix, "?:"), strings.HasPrefix(prefix, "?>"):
+			inner, err := parseAlternation(string(runes[start+2:close]), offset+start+2, state)
+			if err != nil {
+				return nil, err
+			}
+			inner.groupCount = state.groupCount
+			if runes[start+1] == '>' {
+				return AtomicGroupMatcher{pattern: inner}, nil
+			}
+			return GroupMatcher{pattern: inner}, nil
+		case strings.HasPrefix(prefix, "?P<"), strings.HasPrefix(prefix, "?<") && prefix != "?<=" && prefix != "?<!":
+			nameStart := start + 2
+			if runes[start+1] == 'P' {
+				nameStart++
+			}
+			nameEnd := nameStart
+			for nameEnd < close && runes[nameEnd] != '>' {
+				nameEnd++
+			}
+			name := string(runes[nameStart:nameEnd])
+			if nameEnd == close || !isGroupName(name) || slices.Contains(state.names, name) {
+				return nil, newParseError(ErrInvalidNamedCapture, runes, open, min(nameEnd+1, close+1), offset)
+			}
+			return parseCapturingGroup(runes, nameEnd+1, close, offset, name, state)
+		case strings.HasPrefix(prefix, "?="):
+			start += 2
+		case strings.HasPrefix(prefix, "?!"):
+			look.negated = true
+			start += 2
+		case prefix == "?<=":
+			look.behind = true
+			start += 3
+		case prefix == "?<!":
+			look.behind, look.negated = true, true
+			start += 3
+		default:
+			return nil, newParseError(ErrInvalidGroup, runes, open, min(start+2, close+1), offset)
+		}
+
+		inner, err := parseAlternation(string(runes[start:close]), offset+start, state)
+		if err != nil {
+			return nil, err
+		}
+		inner.groupCount = state.groupCount
+		look.pattern = inner
+
+		if look.behind {
+			look.minLen, look.maxLen = inner.width()
+			if look.maxLen < 0 {
+				return nil, newParseError(ErrInvalidLookbehind, runes, open, close+1, offset)
+			}
+		}
+		return look, nil
+	}
+
+	return parseCapturingGroup(runes, start, close, offset, "", state)
+}
+
+// parseFlagGroup parses a group such as (?i), which sets or (after a -) clears
+// flags until the end of the enclosing group, or (?i:...), which only applies
+// them to its own body. The first form returns a nil element.
+func parseFlagGroup(runes []rune, open, close, offset int, state *parseState) (PatternElement, error) {
+	options := state.options
+	on, seenDash, seenFlag := true, false, false
+	i := open + 2
+	for ; i < close && runes[i] != ':'; i++ {
+		switch {
+		case runes[i] == '-' && !seenDash:
+			on, seenDash, seenFlag = false, true, false
+		case options.setFlag(runes[i], on):
+			seenFlag = true
+		default:
+			return nil, newParseError(ErrInvalidGroup, runes, open, i+1, offset)
+		}
+	}
+	if !seenFlag {
+		// Nothing after the - as in (?i-)
+		return nil, newParseError(ErrInvalidGroup, runes, open, i+1, offset)
+	}
+
+	if i == close {
+		state.options = options
+		return nil, nil
+	}
+
+	saved := state.options
+	state.options = options
+	defer func() { state.options = saved }()
+
+	inner, err := parseAlternation(string(runes[i+1:close]), offset+i+1, state)
+	if err != nil {
+		return nil, err
+	}
+	inner.groupCount = state.groupCount
+	return GroupMatcher{pattern: inner}, nil
+}
+
+// parseCapturingGroup parses runes[start:close] as the body of the next
+// capturing group, which is unnamed if name is empty
+func parseCapturingGroup(runes []rune, start, close, offset int, name string, state *parseState) (PatternElement, error) {
+	// Assign the group index before parsing the body, so nested groups come after it
+	state.groupCount++
+	state.names = append(state.names, name)
+	groupIndex := state.groupCount
+
+	// Parse the content within the parentheses (may include alternation)
+	inner, err := parseAlternation(string(runes[start:close]), offset+start, state)
+	if err != nil {
+		return nil, err
+	}
+	inner.groupCount = state.groupCount
+	return GroupMatcher{index: groupIndex, name: name, pattern: inner}, nil
+}
+
+// isGroupName reports whether name is a valid group name: a letter or
+// underscore followed by letters, digits and underscores
+func isGroupName(name string) bool {
+	for i, r := range name {
+		if !isWordChar(r) || i == 0 && unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// width returns the least and greatest number of runes that p can match, with
+// a negative greatest width if there is no upper bound
+func (p *Pattern) width() (int, int) {
+	minWidth, maxWidth := 0, 0
+	for _, element := range p.elements {
+		lo, hi := elementWidth(element)
+		minWidth += lo
+		if maxWidth >= 0 {
+			maxWidth = hi + maxWidth
+			if hi < 0 {
+				maxWidth = -1
+			}
+		}
+	}
+	return minWidth, maxWidth
+}
+
+func elementWidth(element PatternElement) (int, int) {
+	if inner, minCount, maxCount, _, ok := quantifierBounds(element); ok {
+		lo, hi := elementWidth(inner)
+		if maxCount < 0 && hi != 0 || hi < 0 {
+			return lo * minCount, -1
+		}
+		return lo * minCount, hi * max(maxCount, 0)
+	}
+
+	switch e := element.(type) {
+	case assertion, LookaroundMatcher:
+		return 0, 0
+	case GroupMatcher:
+		return e.pattern.width()
+	case AtomicGroupMatcher:
+		return e.pattern.width()
+	case AlternationMatcher:
+		minWidth, maxWidth := -1, 0
+		for _, alt := range e.alternatives {
+			lo, hi := alt.width()
+			if minWidth < 0 || lo < minWidth {
+				minWidth = lo
+			}
+			if maxWidth >= 0 && (hi < 0 || hi > maxWidth) {
+				maxWidth = hi
+			}
+		}
+		return minWidth, maxWidth
+	case LinebreakMatcher:
+		return 1, 2
+	case BackReferenceMatcher:
+		// The captured text may be any length
+		return 0, -1
+	default:
+		return 1, 1
+	}
+}
+
+// parsePatternInternal parses a pattern and counts its capturing groups in
+// state. offset is the position of pattern within the string given to
+// ParsePattern, used to locate errors.
+func parsePatternInternal(pattern string, offset int, state *parseState) (*Pattern, error) {
+	var elements []PatternElement
+	var source []sourceRange
+	runes := []rune(pattern)
+	// add appends an element that starts at runes[start] and ends with runes[last]
+	var start int
+	add := func(element PatternElement, last int) {
+		elements = append(elements, element)
+		source = append(source, sourceRange{offset + start, offset + last + 1})
+	}
+
+	for i := 0; i < len(runes); i++ {
+		if state.options.Extended {
+			if i = skipIgnored(runes, i); i == len(runes) {
+				break
+			}
+		}
+		r := runes[i]
+
+		start = i
+		switch r {
+		case '(':
+			// Find matching closing parenthesis
+			open, close := i, -1
+			scanSyntax(runes, open, state.options.Extended, func(j, depth int) bool {
+				if runes[j] == ')' && depth == 0 {
+					close = j
+					return false
+				}
+				return true
+			})
+			if close < 0 {
+				// Mismatched parentheses
+				return nil, newParseError(ErrMissingParen, runes, open, len(runes), offset)
+			}
+			i = close
+
+			group, err := parseGroup(runes, open, i, offset, state)
+			if err != nil {
+				return nil, err
+			}
+			if group == nil {
+				// A flag group such as (?i) matches nothing
+				continue
+			}
+			element, next, err := parseQuantifier(runes, i, offset, state, group)
+			if err != nil {
+				return nil, err
+			}
+			add(element, next)
+			i = next
+
+		case ')':
+			return nil, newParseError(ErrUnexpectedParen, runes, i, i+1, offset)
+
+		case '*', '+', '?', '{':
+			// A quantifier with nothing before it to repeat
+			return nil, newParseError(ErrMissingRepeatArgument, runes, i, i+1, offset)
+
+		case '.':
+			element, next, err := parseQuantifier(runes, i, offset, state, WildcardMatcher{dotAll: state.options.DotAll})
+			if err != nil {
+				return nil, err
+			}
+			add(element, next)
+			i = next
+		case '\\':
+			if i+1 >= len(runes) {
+				return nil, newParseError(ErrTrailingBackslash, runes, i, i+1, offset)
+			}
+			i++
+			var element PatternElement
+			class, isClass := classEscape(runes[i])
+			switch {
+			case runes[i] == 'p' || runes[i] == 'P':
+				var next int
+				var err error
+				if element, next, err = parseUnicodeClass(runes, i-1, offset); err != nil {
+					return nil, err
+				}
+				i = next - 1
+			case isClass:
+				element = class
+			case runes[i] == 'R':
+				element = LinebreakMatcher{}
+			case runes[i] == 'b' || runes[i] == 'B':
+				element = WordBoundaryMatcher{negated: runes[i] == 'B'}
+			case runes[i] == 'A':
+				element = StartAnchorMatcher{}
+			case runes[i] == 'z':
+				element = EndAnchorMatcher{}
+			case runes[i] == 'Z':
+				element = EndAnchorMatcher{finalNewline: true}
+			case runes[i] >= '1' && runes[i] <= '9', runes[i] == 'g', runes[i] == 'k':
+				var err error
+				if element, i, err = parseBackReference(runes, i-1, offset, state); err != nil {
+					return nil, err
+				}
+			case runes[i] == 'Q':
+				// Everything up to \E, or the end of the pattern, is literal;
+				// a quantifier after it applies to the last character only
+				end, closed := quoteEnd(runes, i+1)
+				quoted := runes[i+1 : end+1]
+				if closed {
+					quoted = quoted[:len(quoted)-2]
+				}
+				i = end
+				if len(quoted) == 0 {
+					continue
+				}
+				// Each quoted rune is an element of its own
+				start = i + 1 - len(quoted)
+				if closed {
+					start -= 2
+				}
+				for _, q := range quoted[:len(quoted)-1] {
+					add(state.literal(q), start)
+					start++
+				}
+				element = state.literal(quoted[len(quoted)-1])
+			case runes[i] == 'E':
+				// \E without \Q ends nothing
+				continue
+			default:
+				r, next, ok, err := parseCharEscape(runes, i-1, offset)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return nil, newParseError(ErrInvalidEscape, runes, i-1, i+1, offset)
+				}
+				element = state.literal(r)
+				i = next - 1
+			}
+			element, next, err := parseQuantifier(runes, i, offset, state, element)
+			if err != nil {
+				return nil, err
+			}
+			add(element, next)
+			i = next
+		case '[':
+			set, end, err := parseCharacterSet(runes, i, offset, state)
+			if err != nil {
+				return nil, err
+			}
+			element, next, err := parseQuantifier(runes, end, offset, state, set)
+			if err != nil {
+				return nil, err
+			}
+			add(element, next)
+			i = next
+		default:
+			var element PatternElement = state.literal(r)
+			switch r {
+			case '^':
+				element = StartAnchorMatcher{multiline: state.options.Multiline}
+			case '$':
+				element = EndAnchorMatcher{multiline: state.options.Multiline}
+			}
+			element, next, err := parseQuantifier(runes, i, offset, state, element)
+			if err != nil {
+				return nil, err
+			}
+			add(element, next)
+			i = next
+		}
+	}
+
+	return &Pattern{elements: elements, groupCount: state.groupCount, source: source}, nil
+}
+
+// ParsePattern converts a pattern string into a sequence of pattern elements.
+// An optional ParseOptions changes how the pattern is interpreted. Syntax
+// errors are reported as a *ParseError.
+func ParsePattern(pattern string, opts ...ParseOptions) (*Pattern, error) {
+	state := &parseState{names: []string{""}}
+	if len(opts) > 0 {
+		state.options = opts[0]
+	}
+	p, err := parseAlternation(pattern, 0, state)
+	if err != nil {
+		return nil, err
+	}
+	if p.compiledSize() > state.options.maxProgramSize() {
+		// Each repetition is within bounds, but there are too many of them
+		runes := []rune(pattern)
+		return nil, newParseError(ErrInvalidRepeatSize, runes, 0, len(runes), 0)
+	}
+	p.groupCount = state.groupCount
+	p.names = state.names
+	p.longest = state.options.Longest
+	p.matchLimit = state.options.MatchLimit
+	p.prog = compile(p)
+	if !p.prog.backtrack && !p.prog.finalNewline {
+		p.dfa = newLazyDFA(p.prog)
+	}
+	return p, nil
+}
@@ -0,0 +1,177 @@
+package patterns
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		code     ErrorCode
+		offset   int
+		fragment string
+	}{
+		{`(ab`, ErrMissingParen, 0, `(ab`},
+		{`a(b(c)`, ErrMissingParen, 1, `(b(c)`},
+		{`(?Q)`, ErrInvalidGroup, 0, `(?Q`},
+		{`(?i-)a`, ErrInvalidGroup, 0, `(?i-)`},
+		{`(?P<1a>x)`, ErrInvalidNamedCapture, 0, `(?P<1a>`},
+		{`(?<n>a)(?<n>b)`, ErrInvalidNamedCapture, 7, `(?<n>`},
+		{`(?<=a+)`, ErrInvalidLookbehind, 0, `(?<=a+)`},
+		{`a)b`, ErrUnexpectedParen, 1, `)`},
+		{`[abc`, ErrMissingBracket, 0, `[abc`},
+		{`[z-a]`, ErrInvalidCharRange, 1, `z-a`},
+		{`[[:foo:]]`, ErrInvalidCharClass, 1, `[:foo:]`},
+		{`[:space:]`, ErrBareCharClass, 0, `[:space:]`},
+		{`[[.foo.]]`, ErrInvalidCollatingElement, 1, `[.foo.]`},
+		{`\p{Foo}`, ErrInvalidUnicodeClass, 0, `\p{Foo}`},
+		{`\x{110000}`, ErrInvalidEscape, 0, `\x{110000}`},
+		{`ab\y`, ErrInvalidEscape, 2, `\y`},
+		{`a\`, ErrTrailingBackslash, 1, `\`},
+		{`(a)\9`, ErrInvalidBackReference, 3, `\9`},
+		{`\k<nope>`, ErrInvalidBackReference, 0, `\k<nope>`},
+		{`*a`, ErrMissingRepeatArgument, 0, `*`},
+		{`a|+`, ErrMissingRepeatArgument, 2, `+`},
+		{`a**`, ErrInvalidNestedRepeat, 1, `**`},
+		{`(?x)a+ *`, ErrInvalidNestedRepeat, 5, `+ *`},
+		{`a{1`, ErrInvalidRepeatOp, 1, `{1`},
+		{`a{3,1}`, ErrInvalidRepeatSize, 1, `{3,1}`},
+		{`a(b|c{2,1})`, ErrInvalidRepeatSize, 5, `{2,1}`},
+		{`a{1001}`, ErrInvalidRepeatSize, 1, `{1001}`},
+	}
+	for _, tt := range tests {
+		_, err := ParsePattern(tt.pattern)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("ParsePattern(%q): got %v, want a *ParseError", tt.pattern, err)
+			continue
+		}
+		want := ParseError{Code: tt.code, Offset: tt.offset, Fragment: tt.fragment}
+		if *parseErr != want {
+			t.Errorf("ParsePattern(%q): got %#v, want %#v", tt.pattern, *parseErr, want)
+		}
+	}
+}
+
+func TestParseErrorString(t *testing.T) {
+	_, err := ParsePattern(`a{3,1}`)
+	if got, want := err.Error(), "invalid repeat count at offset 1: `{3,1}`"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNestedRepeatSize(t *testing.T) {
+	tests := []struct {
+		pattern string
+		offset  int
+	}{
+		{`(a{1000}){1000}`, 9},
+		{`((a{1000}){1000}){1000}`, 10},
+		{`(?:a{500}b{500}){200}`, 16},
+		{`(a{10}){1000}(a{10}){1000}(a{10}){1000}(a{10}){1000}(a{10}){1000}(a{10}){1000}(a{10}){1000}(a{10}){1000}(a{10}){1000}(a{10}){1000}(a{10}){1000}`, 0},
+	}
+	for _, tt := range tests {
+		_, err := ParsePattern(tt.pattern)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || parseErr.Code != ErrInvalidRepeatSize || parseErr.Offset != tt.offset {
+			t.Errorf("%q: got %v, want %q at offset %d", tt.pattern, err, ErrInvalidRepeatSize, tt.offset)
+		}
+	}
+
+	if _, err := ParsePattern(`(\d{1,3}\.){3}(a{100}){100}`); err != nil {
+		t.Errorf("got %v, want the pattern to fit", err)
+	}
+}
+
+func TestRepeatLimitOptions(t *testing.T) {
+	tests := []struct {
+		pattern string
+		opts    ParseOptions
+		ok      bool
+	}{
+		{`a{1000}`, ParseOptions{}, true},
+		{`a{1001}`, ParseOptions{}, false},
+		{`a{5000}`, ParseOptions{MaxRepeat: 5000}, true},
+		{`a{5001}`, ParseOptions{MaxRepeat: 5000}, false},
+		{`a{99999999999999999999}`, ParseOptions{MaxRepeat: 5000}, false},
+		{`a{11}`, ParseOptions{MaxRepeat: 10}, false},
+		{`(a{1000}){1000}`, ParseOptions{MaxProgramSize: 2000000}, true},
+		{`a{200}`, ParseOptions{MaxProgramSize: 100}, false},
+		{`a{100}`, ParseOptions{MaxProgramSize: 100}, true},
+	}
+	for _, tt := range tests {
+		_, err := ParsePattern(tt.pattern, tt.opts)
+		var parseErr *ParseError
+		if tt.ok && err != nil {
+			t.Errorf("ParsePattern(%q, %+v): %v", tt.pattern, tt.opts, err)
+		} else if !tt.ok && (!errors.As(err, &parseErr) || parseErr.Code != ErrInvalidRepeatSize) {
+			t.Errorf("ParsePattern(%q, %+v): got %v, want %q", tt.pattern, tt.opts, err, ErrInvalidRepeatSize)
+		}
+	}
+
+	// Limits chosen by one caller do not affect another parsing at once
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if _, err := ParsePattern(`a{2000}`, ParseOptions{MaxRepeat: 2000}); err != nil {
+					t.Errorf("with MaxRepeat 2000: %v", err)
+					return
+				}
+				if _, err := ParsePattern(`a{2000}`); err == nil {
+					t.Error("with the default MaxRepeat: got no error")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestExtendedComments(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    bool
+	}{
+		{"(?x)a # |b", "b", false},
+		{"(?x)a # |b", "a", true},
+		{"(?x)(a # )\n)", "a", true},
+		{"(?x)(a # (\n)", "a", true},
+		{"(?x)(a # |\n|b)c", "bc", true},
+		{"(?x)(a # (\n)|b", "b", true},
+		{"a # |b", "b", true},
+		{"(?x:a # )\n)b|c", "ab", true},
+		{"(?x:a # )\n)b # |c", "ab # ", true},
+		{"(?x:a # )\n)b # |c", "ab", false},
+		{"(?x:a)#|c", "c", true},
+		{"(?x)(?-x)a#|c", "c", true},
+		{"(?x)a[#]|c", "c", true},
+		{`(?x)a\#|c`, "c", true},
+		{"((?x)a # )\n)b#|c", "c", true},
+		// Whitespace and comments may separate an atom from its quantifier
+		{"(?x) a + b", "aab", true},
+		{"(?x) a + b", "a b", false},
+		{`(?x)\d {3}`, "123", true},
+		{`(?x)\d {3}`, "12 3", false},
+		{"(?x)(ab) # twice\n {2}", "abab", true},
+		{"(?x)a # lazy\n + ? b", "aab", true},
+		{"(?x)[ab] * + b", "aab", false},
+		{"a +b", "a b", true},
+		{"a +b", "ab", false},
+	}
+	for _, tt := range tests {
+		p, err := ParsePattern(tt.pattern)
+		if err != nil {
+			t.Errorf("ParsePattern(%q): %v", tt.pattern, err)
+			continue
+		}
+		if got := p.Match([]rune(tt.input)); got != tt.want {
+			t.Errorf("%q on %q: got %v, want %v", tt.pattern, tt.input, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package patterns
+
+// thread is a single NFA thread: a position in the program and the capture
+// slots recorded along the path that led to it
+type thread struct {
+	pc   int
+	caps []int
+	hist *captureLog // only kept when recording every capture
+}
+
+// captureLog records the text captured by a group each time it matched along
+// the path taken by a thread, most recent first. Threads that share a path
+// share its log.
+type captureLog struct {
+	group      int
+	start, end int
+	prev       *captureLog
+}
+
+// record returns hist with the capture that the end slot arg of caps has just
+// completed added, if arg is the end slot of a group
+func record(hist *captureLog, caps []int, arg int) *captureLog {
+	if arg < 2 || arg%2 == 0 {
+		return hist
+	}
+	return &captureLog{group: arg / 2, start: caps[arg-1], end: caps[arg], prev: hist}
+}
+
+// pikeVM executes a program over an input by advancing every live thread in
+// lockstep, one rune at a time. Threads that reach the same pc at the same
+// input position are merged, keeping only the highest-priority one, so the
+// running time is O(len(program) * len(input)) whatever the pattern.
+type pikeVM struct {
+	prog    *program
+	input   []rune
+	visited []int // generation in which each pc was last added to a list
+	gen     int
+	history bool // whether threads keep a log of every capture
+	limit   *matchLimit
+}
+
+func newPikeVM(prog *program, input []rune) *pikeVM {
+	return &pikeVM{
+		prog:    prog,
+		input:   input,
+		visited: make([]int, len(prog.insts)),
+	}
+}
+
+// run runs the program against the input and returns the thread of the
+// leftmost match that starts at or after start, preferring earlier
+// alternatives and greedier repetitions as a backtracker would. Its caps are
+// nil if there is no match, or if the limit stopped the search. Assertions
+// still see the input before start.
+func (m *pikeVM) run(start int) thread {
+	var matched thread
+	var clist, nlist []thread
+
+	m.gen++
+	clist = m.add(clist, 0, start, thread{caps: m.newCaps()})
+
+	for pos := start; ; pos++ {
+		if len(clist) == 0 && matched.caps != nil {
+			break
+		}
+		if !m.limit.poll() {
+			return thread{}
+		}
+
+		m.gen++
+		nlist = nlist[:0]
+	step:
+		for _, t := range clist {
+			in := &m.prog.insts[t.pc]
+			switch in.op {
+			case instMatch:
+				// Every remaining thread has lower priority than this one
+				matched = t
+				break step
+			case instRune:
+				if pos < len(m.input) && in.elem.Match(m.input[pos]) {
+					nlist = m.add(nlist, t.pc+1, pos+1, t)
+				}
+			}
+		}
+
+		if pos >= len(m.input) {
+			break
+		}
+		if matched.caps == nil {
+			// Start a new attempt at the next position, behind every thread
+			// that started earlier so that the leftmost match wins
+			nlist = m.add(nlist, 0, pos+1, thread{caps: m.newCaps()})
+		}
+		clist, nlist = nlist, clist
+	}
+
+	return matched
+}
+
+// add follows pc through every instruction that does not consume input,
+// carrying the captures of t, and appends the threads that end up waiting on
+// a rune (or a match) to list, in priority order
+func (m *pikeVM) add(list []thread, pc, pos int, t thread) []thread {
+	if m.visited[pc] == m.gen {
+		return list
+	}
+	m.visited[pc] = m.gen
+
+	in := &m.prog.insts[pc]
+	switch in.op {
+	case instJmp:
+		return m.add(list, in.x, pos, t)
+	case instSplit:
+		list = m.add(list, in.x, pos, t)
+		return m.add(list, in.y, pos, t)
+	case instSave:
+		saved := make([]int, len(t.caps))
+		copy(saved, t.caps)
+		saved[in.arg] = pos
+		hist := t.hist
+		if m.history {
+			hist = record(hist, saved, in.arg)
+		}
+		return m.add(list, pc+1, pos, thread{caps: saved, hist: hist})
+	case instEmpty:
+		if emptyOp(in.arg)&^emptyOpAt(m.input, pos) != 0 {
+			return list
+		}
+		return m.add(list, pc+1, pos, t)
+	default:
+		t.pc = pc
+		return append(list, t)
+	}
+}
+
+func (m *pikeVM) newCaps() []int {
+	return newCaptures(m.prog.numCap)
+}
+
+// newCaptures returns n capture slots, all unset
+func newCaptures(n int) []int {
+	caps := make([]int, n)
+	for i := range caps {
+		caps[i] = -1
+	}
+	return caps
+}
@@ -0,0 +1,91 @@
+package patterns
+
+import "unicode"
+
+// posixClass defines a named class usable as [[:name:]] inside a bracket
+// expression, both as the ASCII ranges of the C locale and as a Unicode-aware
+// predicate
+type posixClass struct {
+	ascii   []runeRange
+	unicode func(r rune) bool
+}
+
+var posixClasses = map[string]posixClass{
+	"alnum": {
+		ascii:   []runeRange{{'0', '9'}, {'A', 'Z'}, {'a', 'z'}},
+		unicode: func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) },
+	},
+	"alpha": {
+		ascii:   []runeRange{{'A', 'Z'}, {'a', 'z'}},
+		unicode: unicode.IsLetter,
+	},
+	"blank": {
+		ascii:   []runeRange{{'\t', '\t'}, {' ', ' '}},
+		unicode: func(r rune) bool { return r == '\t' || unicode.Is(unicode.Zs, r) },
+	},
+	"cntrl": {
+		ascii:   []runeRange{{0x00, 0x1f}, {0x7f, 0x7f}},
+		unicode: unicode.IsControl,
+	},
+	"digit": {
+		ascii:   []runeRange{{'0', '9'}},
+		unicode: unicode.IsDigit,
+	},
+	"graph": {
+		ascii:   []runeRange{{'!', '~'}},
+		unicode: func(r rune) bool { return unicode.IsGraphic(r) && !unicode.IsSpace(r) },
+	},
+	"lower": {
+		ascii:   []runeRange{{'a', 'z'}},
+		unicode: unicode.IsLower,
+	},
+	"print": {
+		ascii:   []runeRange{{' ', '~'}},
+		unicode: unicode.IsPrint,
+	},
+	"punct": {
+		ascii:   []runeRange{{'!', '/'}, {':', '@'}, {'[', '`'}, {'{', '~'}},
+		unicode: func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSymbol(r) },
+	},
+	"space": {
+		ascii:   []runeRange{{'\t', '\r'}, {' ', ' '}},
+		unicode: unicode.IsSpace,
+	},
+	"upper": {
+		ascii:   []runeRange{{'A', 'Z'}},
+		unicode: unicode.IsUpper,
+	},
+	"xdigit": {
+		// Hexadecimal digits are ASCII only, whatever the locale
+		ascii:   []runeRange{{'0', '9'}, {'A', 'F'}, {'a', 'f'}},
+		unicode: isHexDigit,
+	},
+}
+
+// PosixClassMatcher matches the characters of a Unicode-aware POSIX class such as [:alpha:]
+type PosixClassMatcher struct {
+	name  string
+	match func(r rune) bool
+}
+
+func (m PosixClassMatcher) Match(r rune) bool {
+	return m.match(r)
+}
+
+// newPosixClass returns the matcher for the POSIX class called name. In ASCII
+// mode the class is a plain set of ranges that can be merged into the
+// enclosing bracket expression.
+func newPosixClass(name string, ascii bool) (PatternElement, bool) {
+	class, ok := posixClasses[name]
+	if !ok {
+		return nil, false
+	}
+	if ascii {
+		return newCharacterSet(class.ascii, nil, false), true
+	}
+	return PosixClassMatcher{name: name, match: class.unicode}, true
+}
+
+func isHexDigit(r rune) bool {
+	return ('0' <= r && r <= '9') || ('a' <= r && r <= 'f') || ('A' <= r && r <= 'F')
+}
@@ -0,0 +1,112 @@
+package patterns
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPosixClasses(t *testing.T) {
+	tests := []struct {
+		ascii   bool
+		class   string
+		in, out string // runes the class must and must not match
+	}{
+		{true, "alnum", "09AZaz", "_- é٣"},
+		{true, "alpha", "AZaz", "0_é"},
+		{true, "blank", "\t ", "\n\u00a0a"},
+		{true, "cntrl", "\x00\x1f\x7f", " a\u0085"},
+		{true, "digit", "0189", "a٣"},
+		{true, "graph", "!~a0", " \x7fé"},
+		{true, "lower", "az", "AZé"},
+		{true, "print", " ~a", "\t\x7fé"},
+		{true, "punct", "!/:@[`{~", "a0 ¿"},
+		{true, "space", "\t\n\v\f\r ", "a\u00a0\u0085"},
+		{true, "upper", "AZ", "azÉ"},
+		{true, "xdigit", "09afAF", "gG"},
+
+		{false, "alnum", "a0é٣Ж", "_- "},
+		{false, "alpha", "aéЖ", "0٣_"},
+		{false, "blank", "\t \u00a0\u3000", "\na"},
+		{false, "cntrl", "\x00\x7f\u0085", " a"},
+		{false, "digit", "0٣", "aⅣ"},
+		{false, "graph", "!aé€", " \u00a0\x7f"},
+		{false, "lower", "aé", "AÉ0"},
+		{false, "print", " aé", "\t\u00a0"},
+		{false, "punct", "!¿€", "a0 "},
+		{false, "space", "\t \u00a0\u3000\u0085", "a_"},
+		{false, "upper", "AÉ", "aé"},
+		{false, "xdigit", "09afAF", "g٣"},
+	}
+	for _, tt := range tests {
+		pattern := "^[[:" + tt.class + ":]]$"
+		p, err := ParsePattern(pattern, ParseOptions{ASCIIClasses: tt.ascii})
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", pattern, err)
+		}
+		for _, r := range tt.in {
+			if !p.Match([]rune{r}) {
+				t.Errorf("%q, ascii=%v: got no match for %q", pattern, tt.ascii, r)
+			}
+		}
+		for _, r := range tt.out {
+			if p.Match([]rune{r}) {
+				t.Errorf("%q, ascii=%v: got a match for %q", pattern, tt.ascii, r)
+			}
+		}
+	}
+}
+
+func TestPosixBracketTerms(t *testing.T) {
+	tests := []struct {
+		pattern string
+		in, out string
+	}{
+		{`^[^[:space:]]$`, "aé_", " \t\u00a0"},
+		{`^[[:digit:][:upper:]_]$`, "7Q_", "q-"},
+		// In the C locale every character is alone in its equivalence class
+		{`^[[=e=]]$`, "e", "éEf"},
+		{`^[[=e=]x]$`, "ex", "é"},
+		// A collating symbol quotes a character that is special in brackets
+		{`^[[.-.]]$`, "-", "a."},
+		{`^[a[.-.]z]$`, "a-z", "b"},
+		{`^[[.].]x]$`, "]x", "."},
+	}
+	for _, tt := range tests {
+		p, err := ParsePattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", tt.pattern, err)
+		}
+		for _, r := range tt.in {
+			if !p.Match([]rune{r}) {
+				t.Errorf("%q: got no match for %q", tt.pattern, r)
+			}
+		}
+		for _, r := range tt.out {
+			if p.Match([]rune{r}) {
+				t.Errorf("%q: got a match for %q", tt.pattern, r)
+			}
+		}
+	}
+}
+
+func TestPosixBracketErrors(t *testing.T) {
+	tests := []struct {
+		pattern string
+		code    ErrorCode
+	}{
+		{`[:space:]`, ErrBareCharClass},
+		{`a[:digit:]+`, ErrBareCharClass},
+		{`[[:Alpha:]]`, ErrInvalidCharClass},
+		{`[[.ab.]]`, ErrInvalidCollatingElement},
+		{`[[.space.]]`, ErrInvalidCollatingElement},
+		{`[[=ae=]]`, ErrInvalidCollatingElement},
+		{`[[==]]`, ErrInvalidCollatingElement},
+	}
+	for _, tt := range tests {
+		_, err := ParsePattern(tt.pattern)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || parseErr.Code != tt.code {
+			t.Errorf("ParsePattern(%q): got %v, want %q", tt.pattern, err, tt.code)
+		}
+	}
+}
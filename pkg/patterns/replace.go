@@ -0,0 +1,150 @@
+package patterns
+
+import (
+	"context"
+	"slices"
+)
+
+// ReplaceAll returns a copy of input in which every match of p, found as by
+// FindAllIndex, is replaced by template with its $ references expanded as by
+// Expand
+func (p *Pattern) ReplaceAll(input []rune, template []rune) []rune {
+	out, _ := p.replaceAll(nil, input, func(dst []rune, caps []int) []rune {
+		return p.expand(dst, template, input, caps)
+	})
+	return out
+}
+
+// ReplaceAllContext is like ReplaceAll, but gives up once ctx is done,
+// returning ctx.Err(), or once one of the matches has taken more steps than
+// the MatchLimit p was parsed with, returning ErrMatchLimit
+func (p *Pattern) ReplaceAllContext(ctx context.Context, input []rune, template []rune) ([]rune, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return p.replaceAll(ctx, input, func(dst []rune, caps []int) []rune {
+		return p.expand(dst, template, input, caps)
+	})
+}
+
+// ReplaceAllLiteral returns a copy of input in which every match of p is
+// replaced by repl, which is used as is without expanding $ references
+func (p *Pattern) ReplaceAllLiteral(input []rune, repl []rune) []rune {
+	out, _ := p.replaceAll(nil, input, func(dst []rune, caps []int) []rune {
+		return append(dst, repl...)
+	})
+	return out
+}
+
+// ReplaceAllFunc returns a copy of input in which every match of p is
+// replaced by what repl returns for the matched text. The replacement is used
+// as is without expanding $ references.
+func (p *Pattern) ReplaceAllFunc(input []rune, repl func([]rune) []rune) []rune {
+	out, _ := p.replaceAll(nil, input, func(dst []rune, caps []int) []rune {
+		return append(dst, repl(slices.Clip(input[caps[0]:caps[1]]))...)
+	})
+	return out
+}
+
+// replaceAll copies input to a new slice, letting repl append the replacement
+// for each match in place of the matched text. Matches are limited under ctx,
+// which may be nil, as allMatches describes.
+func (p *Pattern) replaceAll(ctx context.Context, input []rune, repl func(dst []rune, caps []int) []rune) ([]rune, error) {
+	var out []rune
+	var err error
+	last := 0
+	for caps := range p.allMatches(ctx, input, -1, &err) {
+		out = append(out, input[last:caps[0]]...)
+		out = repl(out, caps)
+		last = caps[1]
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(out, input[last:]...), nil
+}
+
+// Expand appends template to dst and returns the result, replacing each
+// reference in template with the text of input captured by the corresponding
+// group of match, as returned by FindSubmatchIndex. As in package regexp, $1
+// and ${1} refer to a group by number, $name and ${name} refer to a named
+// group, and $$ is a literal $. A $name takes the longest run of letters,
+// digits and underscores, so $1x means ${1x} rather than ${1}x. A reference to
+// a group that does not exist or did not take part in the match expands to
+// nothing.
+func (p *Pattern) Expand(dst []rune, template []rune, input []rune, match []Span) []rune {
+	caps := make([]int, 0, 2*len(match))
+	for _, span := range match {
+		caps = append(caps, span.Start, span.End)
+	}
+	return p.expand(dst, template, input, caps)
+}
+
+func (p *Pattern) expand(dst []rune, template []rune, input []rune, caps []int) []rune {
+	for len(template) > 0 {
+		i := slices.Index(template, '$')
+		if i < 0 {
+			break
+		}
+		dst = append(dst, template[:i]...)
+		template = template[i:]
+
+		if len(template) > 1 && template[1] == '$' {
+			dst = append(dst, '$')
+			template = template[2:]
+			continue
+		}
+		name, rest, ok := templateReference(template)
+		if !ok {
+			// Not a reference, so the $ stands for itself
+			dst = append(dst, '$')
+			template = template[1:]
+			continue
+		}
+		template = rest
+
+		if group := p.subexpIndex(name); group >= 0 && 2*group+1 < len(caps) && caps[2*group] >= 0 {
+			dst = append(dst, input[caps[2*group]:caps[2*group+1]]...)
+		}
+	}
+	return append(dst, template...)
+}
+
+// templateReference parses the $name or ${name} reference at the start of
+// template, returning the name and the text that follows it
+func templateReference(template []rune) (name string, rest []rune, ok bool) {
+	braced := len(template) > 1 && template[1] == '{'
+	start := 1
+	if braced {
+		start = 2
+	}
+	end := start
+	for end < len(template) && isWordChar(template[end]) {
+		end++
+	}
+	if end == start {
+		return "", nil, false
+	}
+	if braced {
+		if end == len(template) || template[end] != '}' {
+			return "", nil, false
+		}
+		return string(template[start:end]), template[end+1:], true
+	}
+	return string(template[start:end]), template[end:], true
+}
+
+// subexpIndex returns the index of the group a template reference names,
+// either by number or by name, or -1 if there is no such group
+func (p *Pattern) subexpIndex(name string) int {
+	n := 0
+	for _, r := range name {
+		if r < '0' || r > '9' {
+			return slices.Index(p.names, name)
+		}
+		if n = 10*n + int(r-'0'); n > p.groupCount {
+			return -1
+		}
+	}
+	return n
+}
@@ -0,0 +1,72 @@
+package patterns
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	const pattern = `(?P<first>\w+) (?P<last>\w+)(!)?`
+	re := regexp.MustCompile(pattern)
+	p, err := ParsePattern(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const input = "ada lovelace"
+
+	for _, template := range []string{
+		`$2, $1`,
+		`${last}, ${first}`,
+		`$last, $first`,
+		`$1x`,
+		`${1}x`,
+		`$$1`,
+		`$$`,
+		`$`,
+		`${first`,
+		`$9 and ${nope}`,
+		`$3`,
+		`${0}`,
+		`cost: $$5`,
+		`$-1`,
+	} {
+		want := string(re.ExpandString(nil, template, input, re.FindStringSubmatchIndex(input)))
+		in := []rune(input)
+		got := string(p.Expand(nil, []rune(template), in, p.FindSubmatchIndex(in)))
+		if got != want {
+			t.Errorf("Expand(%q): got %q, want %q", template, got, want)
+		}
+	}
+}
+
+func TestReplaceAll(t *testing.T) {
+	tests := []struct {
+		pattern, input, template string
+	}{
+		{`a(x*)b`, "-ab-axxb-", `${1}W`},
+		{`a(x*)b`, "-ab-axxb-", `$1W`},
+		{`x*`, "abc", `-`},
+		{`a*`, "baaac", `<$0>`},
+		{`(\w+)@(\w+)`, "mail ada@example now", `$2 at $1`},
+		{`é`, "café é", `e`},
+	}
+	for _, tt := range tests {
+		re := regexp.MustCompile(tt.pattern)
+		p, err := ParsePattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", tt.pattern, err)
+		}
+		input := []rune(tt.input)
+		if got, want := string(p.ReplaceAll(input, []rune(tt.template))), re.ReplaceAllString(tt.input, tt.template); got != want {
+			t.Errorf("ReplaceAll(%q, %q, %q): got %q, want %q", tt.pattern, tt.input, tt.template, got, want)
+		}
+		if got, want := string(p.ReplaceAllLiteral(input, []rune(tt.template))), re.ReplaceAllLiteralString(tt.input, tt.template); got != want {
+			t.Errorf("ReplaceAllLiteral(%q, %q, %q): got %q, want %q", tt.pattern, tt.input, tt.template, got, want)
+		}
+		upper := func(s []rune) []rune { return []rune(strings.ToUpper(string(s))) }
+		if got, want := string(p.ReplaceAllFunc(input, upper)), re.ReplaceAllStringFunc(tt.input, strings.ToUpper); got != want {
+			t.Errorf("ReplaceAllFunc(%q, %q): got %q, want %q", tt.pattern, tt.input, got, want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package patterns
+
+import "iter"
+
+// Split slices input into the substrings between matches of p, as
+// regexp.Regexp.Split does. If n is positive at most n substrings are
+// returned, the last holding the unsplit remainder; if n is zero the result is
+// nil; if n is negative every substring is returned.
+func (p *Pattern) Split(input []rune, n int) [][]rune {
+	if n == 0 {
+		return nil
+	}
+	if len(p.elements) > 0 && len(input) == 0 {
+		return [][]rune{{}}
+	}
+
+	spans := p.FindAllIndex(input, n)
+	pieces := make([][]rune, 0, len(spans))
+	start, end := 0, 0
+	for _, span := range spans {
+		if n > 0 && len(pieces) == n-1 {
+			break
+		}
+		end = span.Start
+		if span.End != 0 {
+			pieces = append(pieces, input[start:end:end])
+		}
+		start = span.End
+	}
+	if end != len(input) {
+		pieces = append(pieces, input[start:])
+	}
+	return pieces
+}
+
+// Token is a piece of the input yielded by Tokenize: either a match of the
+// pattern or the text between two matches
+type Token struct {
+	Text  []rune
+	Span  Span
+	Match bool // whether Text is a match rather than text between matches
+}
+
+// Tokenize returns an iterator over input cut into tokens: each match of p,
+// found as by FindAllIndex, and the text before, between and after them. The
+// text between matches is only yielded when it is not empty.
+func (p *Pattern) Tokenize(input []rune) iter.Seq[Token] {
+	return func(yield func(Token) bool) {
+		offsets := byteOffsets(input)
+		token := func(start, end int, match bool) Token {
+			span := newSpans([]int{start, end}, offsets)[0]
+			return Token{Text: input[start:end:end], Span: span, Match: match}
+		}
+
+		last := 0
+		for caps := range p.allMatches(nil, input, -1, nil) {
+			if caps[0] > last && !yield(token(last, caps[0], false)) {
+				return
+			}
+			if !yield(token(caps[0], caps[1], true)) {
+				return
+			}
+			last = caps[1]
+		}
+		if last < len(input) {
+			yield(token(last, len(input), false))
+		}
+	}
+}
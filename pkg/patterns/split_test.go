@@ -0,0 +1,78 @@
+package patterns
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+)
+
+// splitTests are the cases that package regexp tests its Split with
+var splitTests = []struct {
+	s string
+	r string
+	n int
+}{
+	{"foo:and:bar", ":", -1},
+	{"foo:and:bar", ":", 1},
+	{"foo:and:bar", ":", 2},
+	{"foo:and:bar", "foo", -1},
+	{"foo:and:bar", "bar", -1},
+	{"foo:and:bar", "baz", -1},
+	{"baabaab", "a", -1},
+	{"baabaab", "a*", -1},
+	{"baabaab", "ba*", -1},
+	{"foobar", "f*b*", -1},
+	{"foobar", "f+.*b+", -1},
+	{"foobooboar", "o{2}", -1},
+	{"a,b,c,d,e,f", ",", 3},
+	{"a,b,c,d,e,f", ",", 0},
+	{",", ",", -1},
+	{",,,", ",", -1},
+	{"", ",", -1},
+	{"", ".*", -1},
+	{"", ".+", -1},
+	{"", "", -1},
+	{"foobar", "", -1},
+	{"abaabaccadaaae", "a*", 5},
+	{":x:y:z:", ":", -1},
+}
+
+func TestSplit(t *testing.T) {
+	for _, tt := range splitTests {
+		re := regexp.MustCompile(tt.r)
+		p, err := ParsePattern(tt.r)
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", tt.r, err)
+		}
+		want := re.Split(tt.s, tt.n)
+		var got []string
+		if pieces := p.Split([]rune(tt.s), tt.n); pieces != nil {
+			got = []string{}
+			for _, piece := range pieces {
+				got = append(got, string(piece))
+			}
+		}
+		if !slices.Equal(got, want) || (got == nil) != (want == nil) {
+			t.Errorf("Split(%q, %q, %d): got %q, want %q", tt.s, tt.r, tt.n, got, want)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	p, err := ParsePattern(`\d+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type token struct {
+		text  string
+		match bool
+	}
+	var got []token
+	for tok := range p.Tokenize([]rune("a1bb22é")) {
+		got = append(got, token{string(tok.Text), tok.Match})
+	}
+	want := []token{{"a", false}, {"1", true}, {"bb", false}, {"22", true}, {"é", false}}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,34 @@
+package patterns
+
+import "unicode"
+
+// UnicodeClassMatcher matches the characters of a Unicode general category,
+// script or property, written \p{Greek} or \pL, or any other character when
+// negated, written \P{Greek} or \p{^Greek}
+type UnicodeClassMatcher struct {
+	name    string
+	table   *unicode.RangeTable // nil for Any, which matches every character
+	negated bool
+}
+
+func (m UnicodeClassMatcher) Match(r rune) bool {
+	return (m.table == nil || unicode.Is(m.table, r)) != m.negated
+}
+
+// unicodeTable looks up a Unicode class name among Go's category, script and
+// property tables
+func unicodeTable(name string) (*unicode.RangeTable, bool) {
+	if name == "Any" {
+		return nil, true
+	}
+	if table, ok := unicode.Categories[name]; ok {
+		return table, true
+	}
+	if table, ok := unicode.Scripts[name]; ok {
+		return table, true
+	}
+	if table, ok := unicode.Properties[name]; ok {
+		return table, true
+	}
+	return nil, false
+}
@@ -0,0 +1,72 @@
+package patterns
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUnicodeClasses(t *testing.T) {
+	tests := []struct {
+		pattern string
+		in, out string // runes the pattern must and must not match alone
+	}{
+		{`^\p{Greek}$`, "αΩϿ", "aЖ1"},
+		{`^\p{Lu}$`, "AÉΩ", "aé1"},
+		{`^\pN$`, "1٣Ⅳ½", "a "},
+		{`^\pL$`, "aЖ中", "1_"},
+		{`^\P{L}$`, "1_ ", "aЖ中"},
+		{`^\p{^Greek}$`, "aЖ1", "αΩ"},
+		{`^\P{^Greek}$`, "αΩ", "a"},
+		{`^\p{Any}$`, "a1 \x00", ""},
+		{`^\p{White_Space}$`, " \t\u3000", "a"},
+
+		// Inside brackets, alone, negated and alongside other members
+		{`^[\p{Greek}]$`, "αΩ", "a"},
+		{`^[^\p{Greek}]$`, "a1", "α"},
+		{`^[\p{Greek}\d_]$`, "α7_", "a-"},
+		{`^[\P{L}a]$`, "1a", "bα"},
+	}
+	for _, tt := range tests {
+		p, err := ParsePattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", tt.pattern, err)
+		}
+		for _, r := range tt.in {
+			if !p.Match([]rune{r}) {
+				t.Errorf("%q: got no match for %q", tt.pattern, r)
+			}
+		}
+		for _, r := range tt.out {
+			if p.Match([]rune{r}) {
+				t.Errorf("%q: got a match for %q", tt.pattern, r)
+			}
+		}
+	}
+}
+
+func TestUnicodeClassErrors(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		offset   int
+		fragment string
+	}{
+		{`\p{Foo}`, 0, `\p{Foo}`},
+		{`a\p{greek}`, 1, `\p{greek}`},
+		{`\pX`, 0, `\pX`},
+		{`\p{Greek`, 0, `\p{Greek`},
+		{`\p`, 0, `\p`},
+		{`[a\P{Nope}]`, 2, `\P{Nope}`},
+	}
+	for _, tt := range tests {
+		_, err := ParsePattern(tt.pattern)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("ParsePattern(%q): got %v, want a *ParseError", tt.pattern, err)
+			continue
+		}
+		want := ParseError{Code: ErrInvalidUnicodeClass, Offset: tt.offset, Fragment: tt.fragment}
+		if *parseErr != want {
+			t.Errorf("ParsePattern(%q): got %#v, want %#v", tt.pattern, *parseErr, want)
+		}
+	}
+}